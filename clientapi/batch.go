@@ -0,0 +1,237 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewAPIRequest returns an APIRequest with every field set to its empty value,
+// ready to have individual fields set for a job
+func NewAPIRequest() *APIRequest {
+	str := func() *string { s := ""; return &s }
+	b := false
+	role := "gostint-role"
+	return &APIRequest{
+		AppRoleID:       str(),
+		AppSecretID:     str(),
+		Token:           str(),
+		GoStintRole:     &role,
+		JobJSON:         str(),
+		QName:           str(),
+		ContainerImage:  str(),
+		ImagePullPolicy: str(),
+		Content:         str(),
+		EntryPoint:      str(),
+		Run:             str(),
+		WorkingDir:      str(),
+		EnvVars:         str(),
+		SecretRefs:      str(),
+		SecretFileType:  str(),
+		ContOnWarnings:  &b,
+		URL:             str(),
+		VaultURL:        str(),
+	}
+}
+
+// BatchJob is a named job request in a batch
+type BatchJob struct {
+	Name    string
+	Request *APIRequest
+}
+
+// BatchResult is the outcome of one job in a batch
+type BatchResult struct {
+	Name     string
+	Response *GetResponse
+	Err      error
+	Started  time.Time
+	Duration time.Duration
+}
+
+// Passed returns true if the job ran successfully
+func (r BatchResult) Passed() bool {
+	return r.Err == nil && r.Response != nil && r.Response.Status == "success"
+}
+
+// RunBatch runs the jobs, at most parallel at a time, sharing the client's
+// vault authentication.  progress, if not nil, is called as each job
+// completes.  The results are returned in the same order as the jobs.
+func (cl *Client) RunBatch(ctx context.Context, jobs []BatchJob, parallel int, progress func(r BatchResult)) []BatchResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]BatchResult, len(jobs))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for i, j := range jobs {
+		wg.Add(1)
+		go func(i int, j BatchJob) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			r := BatchResult{Name: j.Name, Started: time.Now()}
+			if ctx.Err() != nil {
+				r.Err = ctx.Err()
+			} else {
+				cl.debug("Starting batch job %s", j.Name)
+				r.Response, r.Err = cl.RunJob(ctx, j.Request, true)
+			}
+			r.Duration = time.Since(r.Started)
+			results[i] = r
+
+			if progress != nil {
+				mu.Lock()
+				progress(r)
+				mu.Unlock()
+			}
+		}(i, j)
+	}
+	wg.Wait()
+	return results
+}
+
+// Manifest describes a batch of jobs, in YAML or JSON:
+//
+//	parallel: 4
+//	defaults:
+//	  container_image: alpine
+//	jobs:
+//	  - name: os-release
+//	    run: ["cat", "/etc/os-release"]
+//
+// Each job is a job request as for -job-json, plus a name, over the defaults.
+// A job with a matrix is expanded into a job per combination.
+type Manifest struct {
+	Parallel int                      `json:"parallel"`
+	Defaults map[string]interface{}   `json:"defaults"`
+	Jobs     []map[string]interface{} `json:"jobs"`
+}
+
+// LoadManifest reads a batch manifest file, returning its jobs as requests
+// built from base with the job's JSON.  Content folders are relative to the
+// manifest file.
+func LoadManifest(file string, base APIRequest) (*Manifest, []BatchJob, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := Manifest{}
+	if err = unmarshalYAML(file, b, &m); err != nil {
+		return nil, nil, err
+	}
+	if len(m.Jobs) == 0 {
+		return nil, nil, fmt.Errorf("%s: no jobs in manifest", file)
+	}
+
+	dir := filepath.Dir(file)
+	contents := map[string]string{}
+	jobs := []BatchJob{}
+	names := map[string]bool{}
+	for i, mj := range m.Jobs {
+		spec := map[string]interface{}{}
+		for k, v := range m.Defaults {
+			spec[k] = v
+		}
+		for k, v := range mj {
+			spec[k] = v
+		}
+
+		name, _ := spec["name"].(string)
+		delete(spec, "name")
+		if name == "" {
+			name = fmt.Sprintf("job-%d", i+1)
+		}
+		if names[name] {
+			return nil, nil, fmt.Errorf("%s: duplicate job name '%s'", file, name)
+		}
+		names[name] = true
+
+		if err = encodeSpecContent(spec, dir, contents); err != nil {
+			return nil, nil, fmt.Errorf("%s: job %s: %s", file, name, err)
+		}
+		mjobs, err := ExpandMatrix(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: job %s: %s", file, name, err)
+		}
+		for _, mj := range mjobs {
+			req, err := specRequest(mj.Spec, base)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: job %s: %s", file, name, err)
+			}
+			jobName := name
+			if mj.Name != "" {
+				jobName = fmt.Sprintf("%s [%s]", name, mj.Name)
+			}
+			jobs = append(jobs, BatchJob{Name: jobName, Request: req})
+		}
+	}
+	return &m, jobs, nil
+}
+
+// encodeSpecContent replaces a job spec's content folder or tar.gz, relative
+// to dir, with its encoded content.  contents caches the encoding by path.
+func encodeSpecContent(spec map[string]interface{}, dir string, contents map[string]string) error {
+	content, ok := spec["content"].(string)
+	if !ok || content == "" || strings.HasPrefix(content, "targz,") {
+		return nil
+	}
+	if !filepath.IsAbs(content) {
+		content = filepath.Join(dir, content)
+	}
+	if _, ok := contents[content]; !ok {
+		encoded := content
+		if err := EncodeContent(&encoded); err != nil {
+			return err
+		}
+		contents[content] = encoded
+	}
+	spec["content"] = contents[content]
+	return nil
+}
+
+// specRequest returns a copy of base requesting the job spec
+func specRequest(spec map[string]interface{}, base APIRequest) (*APIRequest, error) {
+	js, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	jsStr := string(js)
+	req := base
+	req.JobJSON = &jsStr
+	return &req, nil
+}
@@ -0,0 +1,163 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "play"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "play", "site.yml"), []byte("- hosts: all\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "manifest.yml")
+	err := os.WriteFile(file, []byte(`parallel: 2
+defaults:
+  container_image: alpine
+  content: play
+jobs:
+  - name: first
+    run: [cat, /etc/os-release]
+  - container_image: debian
+  - name: matrix
+    matrix:
+      qname: [a, b]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qname := "flag"
+	m, jobs, err := LoadManifest(file, APIRequest{QName: &qname})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Parallel != 2 {
+		t.Errorf("got parallel %d, want 2", m.Parallel)
+	}
+	want := []struct{ name, image string }{
+		{"first", "alpine"},
+		{"job-2", "debian"},
+		{"matrix [qname=a]", "alpine"},
+		{"matrix [qname=b]", "alpine"},
+	}
+	if len(jobs) != len(want) {
+		t.Fatalf("got %d jobs, want %d", len(jobs), len(want))
+	}
+	for i, w := range want {
+		spec, err := jobs[i].Request.JobSpec()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jobs[i].Name != w.name || spec.ContainerImage != w.image {
+			t.Errorf("job %d: got %s with image %s, want %s with %s", i, jobs[i].Name, spec.ContainerImage, w.name, w.image)
+		}
+		// the base request's fields apply to every job
+		if spec.QName != "flag" {
+			t.Errorf("%s: got qname %s, want the request's", jobs[i].Name, spec.QName)
+		}
+		if !strings.HasPrefix(spec.Content, "targz,") {
+			t.Errorf("%s: content %.20q not encoded from the folder beside the manifest", jobs[i].Name, spec.Content)
+		}
+	}
+
+	for _, tc := range []struct{ name, manifest, err string }{
+		{"no jobs", "parallel: 2\n", "no jobs in manifest"},
+		{"duplicate names", "jobs:\n  - name: a\n  - name: a\n", "duplicate job name 'a'"},
+		{"missing content", "jobs:\n  - content: missing\n", "job job-1"},
+	} {
+		f := filepath.Join(dir, tc.name+".yml")
+		if err := os.WriteFile(f, []byte(tc.manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := LoadManifest(f, APIRequest{}); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestRunBatchBounded(t *testing.T) {
+	var mu sync.Mutex
+	active, most := 0, 0
+	g := startFakeGostint(t, &fakeGostint{submit: func(n int, w http.ResponseWriter, r *http.Request) bool {
+		mu.Lock()
+		active++
+		if active > most {
+			most = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return false
+	}})
+	v := startFakeVault(t, &fakeVault{})
+	cl, err := NewClient(WithURL(g.URL), WithVaultURL(v.URL), WithVaultToken("root"), WithJournal(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	js := `{"container_image": "alpine"}`
+	jobs := []BatchJob{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		jobs = append(jobs, BatchJob{Name: name, Request: &APIRequest{JobJSON: &js}})
+	}
+	progressed := 0
+	results := cl.RunBatch(context.Background(), jobs, 2, func(r BatchResult) { progressed++ })
+	for i, r := range results {
+		if r.Name != jobs[i].Name || !r.Passed() {
+			t.Errorf("result %d: got %s, %+v, %v, want %s passed", i, r.Name, r.Response, r.Err, jobs[i].Name)
+		}
+	}
+	if progressed != len(jobs) {
+		t.Errorf("progress called %d times, want %d", progressed, len(jobs))
+	}
+	if most > 2 {
+		t.Errorf("%d jobs ran at once, want at most 2", most)
+	}
+
+	// a cancelled batch runs nothing more
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, r := range cl.RunBatch(ctx, jobs, 2, nil) {
+		if r.Err != context.Canceled {
+			t.Errorf("%s: got %v, want cancelled", r.Name, r.Err)
+		}
+	}
+}
@@ -0,0 +1,756 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// Client is a reusable connection to a gostint API and the Vault it trusts.
+// Create one with NewClient and share it; a Client is safe for concurrent use
+// by multiple goroutines, and several Clients may point at different gostint
+// clusters within the same process.
+type Client struct {
+	url          string
+	vaultURL     string
+	vaultCACert  string
+	vaultNS      string
+	roleID       string
+	secretID     string
+	token        string
+	debugLogging bool
+	tls          TLSConfig
+	hops         []Hop
+	vaultHops    []Hop
+	poll         PollPolicy
+	retries      RetryPolicy
+	mounts       Mounts
+	journal      journal
+	httpClient   *http.Client
+
+	mu    sync.Mutex
+	vault *api.Client // authenticated lazily on first use
+}
+
+// Option configures a Client in NewClient
+type Option func(*Client) error
+
+// WithURL sets the gostint API URL, e.g. https://somewhere:3232
+func WithURL(url string) Option {
+	return func(cl *Client) error {
+		cl.url = url
+		return nil
+	}
+}
+
+// WithVaultURL sets the Vault API URL, defaults to env var VAULT_ADDR
+func WithVaultURL(url string) Option {
+	return func(cl *Client) error {
+		cl.vaultURL = url
+		return nil
+	}
+}
+
+// WithVaultCACert sets the PEM CA bundle to verify Vault's certificate,
+// defaults to env var VAULT_CACERT
+func WithVaultCACert(file string) Option {
+	return func(cl *Client) error {
+		cl.vaultCACert = file
+		return nil
+	}
+}
+
+// WithVaultNamespace sets the Vault Enterprise namespace, defaults to env var
+// VAULT_NAMESPACE
+func WithVaultNamespace(ns string) Option {
+	return func(cl *Client) error {
+		cl.vaultNS = ns
+		return nil
+	}
+}
+
+// WithVaultToken authenticates to Vault with the requestor's token
+func WithVaultToken(token string) Option {
+	return func(cl *Client) error {
+		cl.token = token
+		return nil
+	}
+}
+
+// WithAppRole authenticates to Vault with the requestor's AppRole
+func WithAppRole(roleID, secretID string) Option {
+	return func(cl *Client) error {
+		cl.roleID = roleID
+		cl.secretID = secretID
+		return nil
+	}
+}
+
+// WithDebug enables debug logging for this client
+func WithDebug(enable bool) Option {
+	return func(cl *Client) error {
+		cl.debugLogging = enable
+		return nil
+	}
+}
+
+// WithHTTPClient overrides the http client used to talk to the gostint API
+func WithHTTPClient(hc *http.Client) Option {
+	return func(cl *Client) error {
+		cl.httpClient = hc
+		return nil
+	}
+}
+
+// WithRequest takes the gostint and Vault urls and authentication from a
+// command line APIRequest
+func WithRequest(c *APIRequest) Option {
+	return func(cl *Client) error {
+		if c.URL != nil {
+			cl.url = *c.URL
+		}
+		if c.VaultURL != nil && *c.VaultURL != "" {
+			cl.vaultURL = *c.VaultURL
+		}
+		if c.Token != nil {
+			cl.token = *c.Token
+		}
+		if c.AppRoleID != nil {
+			cl.roleID = *c.AppRoleID
+		}
+		if c.AppSecretID != nil {
+			cl.secretID = *c.AppSecretID
+		}
+		return nil
+	}
+}
+
+// ClientOptions are the connection and transport settings of a Client as
+// given on the command line, empty fields keeping their defaults
+type ClientOptions struct {
+	VaultCACert    string
+	VaultNamespace string
+	AppRoleMount   string // vault mount paths, see Mounts
+	TransitMount   string
+	CACert         string // TLS trust for the gostint api, see TLSConfig:
+	CAPath         string
+	TLSServerName  string
+	ClientCert     string
+	ClientKey      string
+	PinSHA256      string // comma separated
+	Insecure       bool
+	Via            string // comma separated hop urls to the gostint api
+	VaultVia       string // comma separated hop urls to vault
+	Retries        *int   // retries of transient failures
+}
+
+// WithClientOptions applies command line connection and transport settings
+func WithClientOptions(o ClientOptions) Option {
+	return func(cl *Client) error {
+		if o.VaultCACert != "" {
+			cl.vaultCACert = o.VaultCACert
+		}
+		if o.VaultNamespace != "" {
+			cl.vaultNS = o.VaultNamespace
+		}
+		if o.AppRoleMount != "" {
+			cl.mounts.AppRole = strings.Trim(o.AppRoleMount, "/")
+		}
+		if o.TransitMount != "" {
+			cl.mounts.Transit = strings.Trim(o.TransitMount, "/")
+		}
+		if o.CACert != "" {
+			cl.tls.CACert = o.CACert
+		}
+		if o.CAPath != "" {
+			cl.tls.CAPath = o.CAPath
+		}
+		if o.TLSServerName != "" {
+			cl.tls.ServerName = o.TLSServerName
+		}
+		if o.ClientCert != "" {
+			cl.tls.ClientCert = o.ClientCert
+		}
+		if o.ClientKey != "" {
+			cl.tls.ClientKey = o.ClientKey
+		}
+		if o.PinSHA256 != "" {
+			cl.tls.PinSHA256 = strings.Split(o.PinSHA256, ",")
+		}
+		if o.Insecure {
+			cl.tls.Insecure = true
+		}
+		if o.Via != "" {
+			hops, err := ParseHops(o.Via)
+			if err != nil {
+				return err
+			}
+			cl.hops = hops
+		}
+		if o.VaultVia != "" {
+			hops, err := ParseHops(o.VaultVia)
+			if err != nil {
+				return err
+			}
+			cl.vaultHops = hops
+		}
+		if o.Retries != nil {
+			p := cl.retries
+			p.Retries = *o.Retries
+			return WithRetryPolicy(p)(cl)
+		}
+		return nil
+	}
+}
+
+// NewClient returns a Client configured by the options passed
+func NewClient(opts ...Option) (*Client, error) {
+	cl := &Client{
+		vaultURL: os.Getenv("VAULT_ADDR"),
+		poll:     DefaultPollPolicy(),
+		retries:  DefaultRetryPolicy(),
+		mounts:   DefaultMounts(),
+		journal:  defaultJournal(),
+	}
+	for _, opt := range opts {
+		if err := opt(cl); err != nil {
+			return nil, err
+		}
+	}
+	if cl.url == "" {
+		return nil, fmt.Errorf("gostint url must be specified")
+	}
+	if cl.httpClient == nil {
+		tlsConfig, err := cl.tls.build()
+		if err != nil {
+			return nil, err
+		}
+		tr := &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		}
+		if err = setHops(tr, cl.hops); err != nil {
+			return nil, err
+		}
+		cl.httpClient = &http.Client{Transport: tr}
+	}
+	return cl, nil
+}
+
+func (cl *Client) debug(format string, a ...interface{}) {
+	if !cl.debugLogging {
+		return
+	}
+	Debug(format, a...)
+}
+
+// URL returns the gostint API URL this client submits to
+func (cl *Client) URL() string {
+	return cl.url
+}
+
+// vaultClient returns a private copy of the authenticated Vault client, so
+// callers may change its token or wrapping without affecting other goroutines.
+func (cl *Client) vaultClient(ctx context.Context) (*api.Client, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.vault == nil {
+		vc, err := cl.getVaultClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cl.vault = vc
+	}
+
+	vc, err := cl.vault.Clone()
+	if err != nil {
+		return nil, err
+	}
+	vc.SetToken(cl.vault.Token())
+	return vc, nil
+}
+
+func (cl *Client) getVaultClient(ctx context.Context) (*api.Client, error) {
+	if cl.vaultURL == "" {
+		return nil, fmt.Errorf("vault url must be specified")
+	}
+	if cl.token == "" && cl.roleID == "" {
+		return nil, fmt.Errorf("One of vault approle OR token must be specified")
+	}
+	cl.debug("Getting Vault api connection %s", cl.vaultURL)
+
+	cfg := api.DefaultConfig()
+	cfg.Address = cl.vaultURL
+	if cfg.Error != nil {
+		return nil, cfg.Error
+	}
+	cfg.MaxRetries = 0 // retried per the client's RetryPolicy instead
+	if cl.vaultCACert != "" {
+		if err := cfg.ConfigureTLS(&api.TLSConfig{CACert: cl.vaultCACert}); err != nil {
+			return nil, err
+		}
+	}
+	if tr, ok := cfg.HttpClient.Transport.(*http.Transport); ok {
+		if err := setHops(tr, cl.vaultHops); err != nil {
+			return nil, err
+		}
+	} else if len(cl.vaultHops) > 0 {
+		return nil, fmt.Errorf("vault http transport does not support hops")
+	}
+
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// once the client has checked the transport, e.g. for a unix socket
+	cfg.HttpClient.Transport = vaultTransport{cfg.HttpClient.Transport}
+	if cl.vaultNS != "" {
+		client.SetNamespace(cl.vaultNS)
+	}
+
+	token := cl.token
+	if cl.roleID != "" && cl.secretID != "" {
+		cl.debug("Using AppRole authentication")
+		data := map[string]interface{}{
+			"role_id":   cl.roleID,
+			"secret_id": cl.secretID,
+		}
+		sec, err2 := cl.vaultWrite(ctx, client, cl.mounts.loginPath(), data)
+		if err2 != nil {
+			return nil, err2
+		}
+		cl.debug("policies %v", sec.Auth.Policies)
+		token = sec.Auth.ClientToken
+	}
+
+	cl.debug("Authenticating with Vault")
+	client.SetToken(token)
+
+	// Verify the token is good
+	_, err = cl.vaultRead(ctx, client, lookupSelfPath)
+	if err != nil {
+		return nil, err
+	}
+	cl.debug("Vault token authenticated ok")
+	return client, nil
+}
+
+// do sends a request to the gostint api and returns the response body,
+// retrying transient failures
+func (cl *Client) do(ctx context.Context, method, path string, body []byte, token string) ([]byte, error) {
+	var respBody []byte
+	err := cl.retry(ctx, method+" "+path, true, func(ctx context.Context) (err error) {
+		respBody, err = cl.send(ctx, method, path, body, token, nil)
+		return err
+	})
+	return respBody, err
+}
+
+// send makes a single request to the gostint api, with any extra headers
+func (cl *Client) send(ctx context.Context, method, path string, body []byte, token string, hdr http.Header) ([]byte, error) {
+	var rdr *bytes.Reader
+	if body != nil {
+		rdr = bytes.NewReader(body)
+	} else {
+		rdr = bytes.NewReader([]byte{})
+	}
+	req, err := http.NewRequest(
+		method,
+		fmt.Sprintf("%s%s", cl.url, path),
+		rdr,
+	)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Auth-Token", token)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+
+	resp, err := cl.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	cl.debug("Response status: %s", resp.Status)
+	cl.debug("Response headers: %s", resp.Header)
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	cl.debug("Response body:\n%s", string(respBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newAPIError(method, path, resp, respBody)
+	}
+	return respBody, nil
+}
+
+// submitJob posts the job to gostint, returning its response and whether
+// the submission was retried
+func (cl *Client) submitJob(ctx context.Context, jsonBytes []byte, token string, key string) (*submitResponse, bool, error) {
+	cl.debug("Submitting job with idempotency key %s", key)
+	hdr := http.Header{}
+	hdr.Set("Idempotency-Key", key)
+	// not idempotent, so only retried if the job certainly wasn't received
+	var body []byte
+	attempts := 0
+	err := cl.retry(ctx, "submit job", false, func(ctx context.Context) (err error) {
+		attempts++
+		body, err = cl.send(ctx, "POST", jobPath, jsonBytes, token, hdr)
+		return err
+	})
+	if err != nil {
+		return nil, attempts > 1, err
+	}
+
+	subResp := submitResponse{}
+	err = json.Unmarshal(body, &subResp)
+	if err != nil {
+		return nil, attempts > 1, err
+	}
+
+	return &subResp, attempts > 1, nil
+}
+
+// GetJob returns a job status from gostint
+func (cl *Client) GetJob(ctx context.Context, token string, ID string) (*GetResponse, error) {
+	cl.debug("Getting job state")
+	body, err := cl.do(ctx, "GET", jobPath+"/"+ID, nil, token)
+	if err != nil {
+		return nil, err
+	}
+
+	getResp := GetResponse{}
+	err = json.Unmarshal(body, &getResp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &getResp, nil
+}
+
+// APIToken returns a minimal token, created with the client's vault
+// authentication, to authenticate with the gostint api.  Call the returned
+// revoke func once finished with it.
+func (cl *Client) APIToken(ctx context.Context) (string, func(), error) {
+	vc, err := cl.vaultClient(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	cl.debug("Getting minimal token to authenticate with GoStint API")
+	data := map[string]interface{}{
+		"policies": []string{"default"},
+	}
+	sec, err := cl.vaultWrite(ctx, vc, tokenCreatePath, data)
+	if err != nil {
+		return "", nil, err
+	}
+	apiToken := sec.Auth.ClientToken
+
+	revoke := func() {
+		cl.debug("Revoking the minimal authentication token after use")
+		vc.SetToken(apiToken)
+		// use a fresh context, the caller's context may already be cancelled
+		_, err := cl.vaultWrite(context.Background(), vc, revokeSelfPath, nil)
+		if err != nil {
+			log.Printf("Error: revoking token after job completed: %s", err)
+		}
+	}
+	return apiToken, revoke, nil
+}
+
+// ListJobs returns the jobs in gostint matching the queue name and status,
+// either of which may be empty to match all
+func (cl *Client) ListJobs(ctx context.Context, token string, qname string, status string) ([]GetResponse, error) {
+	cl.debug("Listing jobs")
+	q := url.Values{}
+	if qname != "" {
+		q.Set("qname", qname)
+	}
+	if status != "" {
+		q.Set("status", status)
+	}
+	path := jobPath
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	body, err := cl.do(ctx, "GET", path, nil, token)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []GetResponse{}
+	err = json.Unmarshal(body, &jobs)
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// KillJob asks gostint to kill a queued or running job
+func (cl *Client) KillJob(ctx context.Context, token string, ID string) error {
+	cl.debug("Killing job %s", ID)
+	_, err := cl.do(ctx, "POST", fmt.Sprintf("/v1/api/job/kill/%s", ID), nil, token)
+	return err
+}
+
+// killCancelled kills a job whose wait was cancelled or timed out, returning
+// the error describing why
+func (cl *Client) killCancelled(ctx context.Context, token string, ID string) error {
+	why := "cancelled"
+	if ctx.Err() == context.DeadlineExceeded {
+		why = "timed out"
+	}
+	return cl.killUnfinished(token, ID, fmt.Errorf("job %s %s", ID, why))
+}
+
+// killUnfinished kills a job that was given up on because of cause, returning
+// an error wrapping cause
+func (cl *Client) killUnfinished(token string, ID string, cause error) error {
+	// the job's context may already be done, so allow the kill its own time
+	kctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := cl.KillJob(kctx, token, ID); err != nil {
+		return fmt.Errorf("%w, but failed to kill it: %s", cause, err)
+	}
+	return fmt.Errorf("%w, killed", cause)
+}
+
+// sleep waits for d, returning early with the context's error if it is
+// cancelled first
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// RunOptions controls how RunSpec submits and waits for a job
+type RunOptions struct {
+	GoStintRole    string    // Vault AppRole name of the gostint to run on, defaults to gostint-role
+	IdempotencyKey string    // identifies the submission, generated if empty
+	Wait           bool      // wait for the job to complete
+	Output         io.Writer // if set, job output is streamed here while waiting
+}
+
+// RunJob to submit a job request to gostint api.  Only the job fields,
+// GoStintRole, IdempotencyKey and Output of the APIRequest are used, the
+// connection settings are those the Client was created with.  See RunSpec.
+func (cl *Client) RunJob(ctx context.Context, c *APIRequest, waitFor bool) (*GetResponse, error) {
+	cl.debug("Building Job Request")
+	spec, err := c.JobSpec()
+	if err != nil {
+		return nil, err
+	}
+	o := RunOptions{Wait: waitFor, Output: c.Output}
+	if c.GoStintRole != nil {
+		o.GoStintRole = *c.GoStintRole
+	}
+	if c.IdempotencyKey != nil {
+		o.IdempotencyKey = *c.IdempotencyKey
+	}
+	return cl.RunSpec(ctx, spec, o)
+}
+
+// RunSpec submits a job to gostint, optionally waiting for it to complete.
+// If a job was already submitted with the IdempotencyKey it is attached to
+// rather than submitted again.  If ctx is cancelled or times out while
+// submitting, or while waiting, or a deadline of the client's PollPolicy is
+// exceeded, the job is killed in gostint.
+func (cl *Client) RunSpec(ctx context.Context, spec *JobSpec, o RunOptions) (*GetResponse, error) {
+	start := time.Now()
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	if o.GoStintRole == "" {
+		o.GoStintRole = "gostint-role"
+	}
+
+	apiToken, revoke, err := cl.APIToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer revoke()
+
+	key := o.IdempotencyKey
+	var existing *GetResponse
+	if key == "" {
+		if key, err = NewIdempotencyKey(); err != nil {
+			return nil, err
+		}
+	} else if existing, err = cl.FindJob(ctx, apiToken, key); err != nil {
+		return nil, err
+	}
+
+	var ID string
+	if existing != nil {
+		cl.debug("Attaching to job %s already submitted with idempotency key %s", existing.ID, key)
+		ID = existing.ID
+	} else if ID, err = cl.submit(ctx, spec, o.GoStintRole, apiToken, key, o.IdempotencyKey != ""); err != nil {
+		return nil, err
+	}
+
+	var getResp *GetResponse
+	if o.Wait {
+		getResp, err = cl.WaitJobOrKill(ctx, apiToken, ID, o.Output)
+	} else {
+		getResp, err = cl.GetJob(ctx, apiToken, ID)
+		if err != nil {
+			err = fmt.Errorf("job %s: %w", ID, err)
+		}
+	}
+	if err != nil {
+		return getResp, err
+	}
+
+	t := time.Now()
+	elapsed := t.Sub(start)
+	cl.debug("Elapsed time: %.3f seconds", float64(elapsed/time.Millisecond)/1000.0)
+
+	return getResp, nil
+}
+
+// cubbyhole puts the encrypted payload in the cubbyhole of a new token limited
+// to 2 uses, one for the write and one for gostint to read it, returning the
+// token.  A failed attempt may have used up the token's use left for gostint,
+// so each retry creates a new token rather than repeating the write, revoking
+// the failed attempt's token.
+func (cl *Client) cubbyhole(ctx context.Context, vc *api.Client, payload interface{}) (string, error) {
+	cc, err := vc.Clone()
+	if err != nil {
+		return "", err
+	}
+	var cubbyToken string
+	err = cl.retry(ctx, "vault cubbyhole write", true, func(ctx context.Context) error {
+		cl.debug("Getting minimal limited use / ttl token for the cubbyhole")
+		sec, err := vc.Logical().WriteWithContext(ctx, tokenCreatePath, map[string]interface{}{
+			"policies":  []string{"default"},
+			"ttl":       "60m",
+			"use_limit": 2,
+		})
+		if err != nil {
+			return err
+		}
+
+		cl.debug("Putting encrypted payload in a vault cubbyhole")
+		cc.SetToken(sec.Auth.ClientToken)
+		_, err = cc.Logical().WriteWithContext(ctx, cubbyholePath, map[string]interface{}{
+			"payload": payload,
+		})
+		if err != nil {
+			// don't leave the token behind with a use left, using a fresh
+			// context as the caller's may be cancelled
+			if _, rerr := cc.Logical().WriteWithContext(context.Background(), revokeSelfPath, nil); rerr != nil {
+				cl.debug("Revoking the failed cubbyhole token: %s", rerr)
+			}
+			return err
+		}
+		cubbyToken = sec.Auth.ClientToken
+		return nil
+	})
+	return cubbyToken, err
+}
+
+// submit passes the job to gostint via an encrypted cubbyhole, returning the
+// job's id.  explicit is whether the caller gave the idempotency key.
+func (cl *Client) submit(ctx context.Context, spec *JobSpec, role string, apiToken string, key string, explicit bool) (string, error) {
+	vc, err := cl.vaultClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	cl.debug("Getting Wrapped Secret_ID for the GoStint AppRole")
+	vc.SetWrappingLookupFunc(func(op, path string) string { return "1h" })
+	sec, err := cl.vaultWrite(ctx, vc, cl.mounts.secretIDPath(role), nil)
+	if err != nil {
+		return "", err
+	}
+	wrapSecretID := sec.WrapInfo.Token
+	vc.SetWrappingLookupFunc(nil)
+
+	jsonBytes, err := json.Marshal(*spec)
+	if err != nil {
+		return "", err
+	}
+
+	cl.debug("Encrypting the job payload")
+	data := map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(jsonBytes),
+	}
+	sec, err = cl.vaultWrite(ctx, vc, cl.mounts.encryptPath(role), data)
+	if err != nil {
+		return "", err
+	}
+	encryptedPayload := sec.Data["ciphertext"]
+
+	cubbyToken, err := cl.cubbyhole(ctx, vc, encryptedPayload)
+	if err != nil {
+		return "", err
+	}
+
+	cl.debug("Creating job request wrapper to submit")
+	jWrap := jobWrapper{
+		QName:          spec.QName,
+		CubbyToken:     cubbyToken,
+		CubbyPath:      cubbyholePath,
+		WrapSecretID:   wrapSecretID,
+		IdempotencyKey: key,
+	}
+	jWrapBytes, err := json.Marshal(jWrap)
+	if err != nil {
+		return "", err
+	}
+
+	return cl.submitOrFind(ctx, jWrapBytes, apiToken, key, explicit)
+}
@@ -30,32 +30,22 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
-	"crypto/tls"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/fatih/color"
-	"github.com/hashicorp/vault/api"
 )
 
-var enableDebug bool
-var token string
-
-func debug(format string, a ...interface{}) {
-	if !enableDebug {
-		return
-	}
-	Debug(format, a...)
-}
+// noDebug discards debug logging, for the package level helpers
+func noDebug(format string, a ...interface{}) {}
 
 // Debug in color...
 func Debug(format string, a ...interface{}) {
@@ -65,7 +55,10 @@ func Debug(format string, a ...interface{}) {
 	fmt.Println()
 }
 
-// APIRequest structure the job request passed to the client api
+// APIRequest structure the job request passed to the client api, as given on
+// the command line with JSON encoded lists, nil fields are left unset - Go
+// callers can build a JobSpec and call Client.RunSpec instead.  The client's
+// connection and transport settings are ClientOptions.
 type APIRequest struct {
 	AppRoleID       *string
 	AppSecretID     *string // AppRole auth or Token
@@ -85,46 +78,46 @@ type APIRequest struct {
 	ContOnWarnings  *bool
 	URL             *string
 	VaultURL        *string
+	IdempotencyKey  *string   // identifies the submission, generated if empty
+	Strict          *bool     // reject job fields unknown to the client
+	DefaultQName    *string   // queue for a job that doesn't set one
+	DefaultImage    *string   // container image for a job that doesn't set one
+	Output          io.Writer // if set, job output is streamed here while waiting
 }
 
-type job struct {
-	QName           string   `json:"qname"`
-	ContainerImage  string   `json:"container_image"`
-	ImagePullPolicy string   `json:"image_pull_policy"`
-	Content         string   `json:"content"`
-	EntryPoint      []string `json:"entrypoint"`
-	Run             []string `json:"run"`
-	WorkingDir      string   `json:"working_directory"`
-	EnvVars         []string `json:"env_vars"`
-	SecretRefs      []string `json:"secret_refs"`
-	SecretFileType  string   `json:"secret_file_type"`
-	ContOnWarnings  bool     `json:"cont_on_warnings"`
+// JobSpec returns the job described by the request: its JobJSON overridden by
+// any of the individual job fields set, with the default queue and image where
+// neither sets them
+func (c APIRequest) JobSpec() (*JobSpec, error) {
+	return buildJob(c)
 }
 
 // func buildJob(c APIRequest) (*[]byte, error) {
-func buildJob(c APIRequest) (*job, error) {
-	debug("Building Job Request")
-	j := job{}
+func buildJob(c APIRequest) (*JobSpec, error) {
+	j := JobSpec{}
 
-	if *c.JobJSON != "" {
+	if c.JobJSON != nil && *c.JobJSON != "" {
 		err := json.Unmarshal([]byte(*c.JobJSON), &j)
 		if err != nil {
 			return nil, err
 		}
+		if c.Strict != nil && *c.Strict && len(j.Extra) > 0 {
+			return nil, fmt.Errorf("unknown job fields: %s", strings.Join(j.UnknownFields(), ", "))
+		}
 	}
-	if *c.QName != "" {
+	if c.QName != nil && *c.QName != "" {
 		j.QName = *c.QName
 	}
-	if *c.ContainerImage != "" {
+	if c.ContainerImage != nil && *c.ContainerImage != "" {
 		j.ContainerImage = *c.ContainerImage
 	}
-	if *c.ImagePullPolicy != "" {
+	if c.ImagePullPolicy != nil && *c.ImagePullPolicy != "" {
 		j.ImagePullPolicy = *c.ImagePullPolicy
 	}
-	if *c.Content != "" {
+	if c.Content != nil && *c.Content != "" {
 		j.Content = *c.Content
 	}
-	if *c.EntryPoint != "" {
+	if c.EntryPoint != nil && *c.EntryPoint != "" {
 		// j.EntryPoint = *c.EntryPoint
 		eps := make([]string, 0)
 		err := json.Unmarshal([]byte(*c.EntryPoint), &eps)
@@ -133,7 +126,7 @@ func buildJob(c APIRequest) (*job, error) {
 		}
 		j.EntryPoint = eps
 	}
-	if *c.Run != "" {
+	if c.Run != nil && *c.Run != "" {
 		// j.Run = *c.Run
 		eps := make([]string, 0)
 		err := json.Unmarshal([]byte(*c.Run), &eps)
@@ -142,10 +135,10 @@ func buildJob(c APIRequest) (*job, error) {
 		}
 		j.Run = eps
 	}
-	if *c.WorkingDir != "" {
+	if c.WorkingDir != nil && *c.WorkingDir != "" {
 		j.WorkingDir = *c.WorkingDir
 	}
-	if *c.EnvVars != "" {
+	if c.EnvVars != nil && *c.EnvVars != "" {
 		eps := make([]string, 0)
 		err := json.Unmarshal([]byte(*c.EnvVars), &eps)
 		if err != nil {
@@ -153,7 +146,7 @@ func buildJob(c APIRequest) (*job, error) {
 		}
 		j.EnvVars = eps
 	}
-	if *c.SecretRefs != "" {
+	if c.SecretRefs != nil && *c.SecretRefs != "" {
 		// j.SecretRefs = *c.SecretRefs
 		eps := make([]string, 0)
 		err := json.Unmarshal([]byte(*c.SecretRefs), &eps)
@@ -162,95 +155,19 @@ func buildJob(c APIRequest) (*job, error) {
 		}
 		j.SecretRefs = eps
 	}
-	if *c.SecretFileType != "" {
+	if c.SecretFileType != nil && *c.SecretFileType != "" {
 		j.SecretFileType = *c.SecretFileType
 	}
-	if *c.ContOnWarnings {
+	if c.ContOnWarnings != nil && *c.ContOnWarnings {
 		j.ContOnWarnings = *c.ContOnWarnings
 	}
-	return &j, nil
-}
-
-func getVaultClient(url string, c *APIRequest) (*api.Client, error) {
-	debug("Getting Vault api connection %s", url)
-
-	cfg := api.DefaultConfig()
-	cfg.Address = url
-
-	client, err := api.NewClient(cfg)
-	if err != nil {
-		return nil, err
-	}
-
-	if *c.AppRoleID != "" && *c.AppSecretID != "" {
-		debug("Using AppRole authentication")
-		data := map[string]interface{}{
-			"role_id":   *c.AppRoleID,
-			"secret_id": *c.AppSecretID,
-		}
-		sec, err2 := client.Logical().Write("/auth/approle/login", data)
-		if err2 != nil {
-			return nil, err2
-		}
-		debug("policies %v", sec.Auth.Policies)
-		*c.Token = sec.Auth.ClientToken
-	}
-
-	debug("Authenticating with Vault")
-	client.SetToken(*c.Token)
-
-	// Verify the token is good
-	_, err = client.Logical().Read("auth/token/lookup-self")
-	if err != nil {
-		return nil, err
-	}
-	debug("Vault token authenticated ok")
-	return client, nil
-}
-
-func submitJob(c *APIRequest, jsonBytes *[]byte, token string) (*submitResponse, error) {
-	debug("Submitting job")
-	req, err := http.NewRequest(
-		"POST",
-		fmt.Sprintf("%s/v1/api/job", *c.URL),
-		bytes.NewBuffer(*jsonBytes),
-	)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("X-Auth-Token", token)
-	req.Header.Set("Content-Type", "application/json")
-
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			// TODO: parameterise this
-			InsecureSkipVerify: true,
-			// TODO: more Cert/CA options for trust
-		},
-	}
-
-	client := &http.Client{Transport: tr}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	debug("Response status: %s", resp.Status)
-	debug("Response headers: %s", resp.Header)
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if j.QName == "" && c.DefaultQName != nil {
+		j.QName = *c.DefaultQName
 	}
-	debug("Response body:\n%s", string(body))
-
-	subResp := submitResponse{}
-	err = json.Unmarshal(body, &subResp)
-	if err != nil {
-		return nil, err
+	if j.ContainerImage == "" && c.DefaultImage != nil {
+		j.ContainerImage = *c.DefaultImage
 	}
-
-	return &subResp, nil
+	return &j, nil
 }
 
 type submitResponse struct {
@@ -259,50 +176,6 @@ type submitResponse struct {
 	QName  string `json:"qname"`
 }
 
-// GetJob returns a job status from gostint
-func GetJob(c *APIRequest, token string, ID string) (*GetResponse, error) {
-	debug("Getting job state")
-	req, err := http.NewRequest(
-		"GET",
-		fmt.Sprintf("%s/v1/api/job/%s", *c.URL, ID),
-		nil,
-	)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("X-Auth-Token", token)
-	req.Header.Set("Content-Type", "application/json")
-
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-
-	client := &http.Client{Transport: tr}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	debug("Response status: %s", resp.Status)
-	debug("Response headers: %s", resp.Header)
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	debug("Response body:\n%s", string(body))
-
-	getResp := GetResponse{}
-	err = json.Unmarshal(body, &getResp)
-	if err != nil {
-		return nil, err
-	}
-
-	return &getResp, nil
-}
-
 // GetResponse structure holds response from gostint job query
 type GetResponse struct {
 	ID             string `json:"_id"`
@@ -315,6 +188,7 @@ type GetResponse struct {
 	Ended          string `json:"ended"`
 	Output         string `json:"output"`
 	ReturnCode     int    `json:"return_code"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 func (r *GetResponse) String() string {
@@ -327,147 +201,47 @@ func (r *GetResponse) String() string {
 	)
 }
 
-// RunJob to submit a job request to gostint api
+// RunJob to submit a job request to gostint api, polling every pollSecs
+// seconds, or per DefaultPollPolicy if not greater than zero.
+//
+// Deprecated: create a Client with NewClient and use its RunJob method, which
+// reuses connections and accepts a context.
 func RunJob(c *APIRequest, debugLogging bool, pollSecs int, waitFor bool) (*GetResponse, error) {
-	start := time.Now()
-
-	enableDebug = debugLogging
-	pollIntervalSecs := pollSecs
-
-	job, err := buildJob(*c)
-	if err != nil {
-		return nil, err
-	}
-
-	if *c.VaultURL == "" {
-		*c.VaultURL = os.Getenv("VAULT_ADDR")
-	}
-
-	vc, err := getVaultClient(*c.VaultURL, c)
-	if err != nil {
-		return nil, err
-	}
-
-	// TODO: this only supports direct connection to gostint api, need to be able
-	// to support routing via intermediary(s)
-	debug("Getting minimal token to authenticate with GoStint API")
-	data := map[string]interface{}{
-		"policies": []string{"default"},
-	}
-	sec, err := vc.Logical().Write("auth/token/create", data)
-	if err != nil {
-		return nil, err
-	}
-	apiToken := sec.Auth.ClientToken
-
-	defer func() {
-		debug("Revoking the minimal authentication token after use")
-		_, err = vc.Logical().Write("auth/token/revoke-self", nil)
-		if err != nil {
-			log.Printf("Error: revoking token after job completed: %s", err)
-		}
-	}()
-
-	debug("Getting Wrapped Secret_ID for the GoStint AppRole")
-	vc.SetWrappingLookupFunc(func(op, path string) string { return "1h" })
-	sec, err = vc.Logical().Write(
-		fmt.Sprintf("auth/approle/role/%s/secret-id", *c.GoStintRole),
-		nil,
+	poll := DefaultPollPolicy()
+	if pollSecs > 0 {
+		poll = FixedPollPolicy(time.Duration(pollSecs) * time.Second)
+	}
+	cl, err := NewClient(
+		WithRequest(c),
+		WithDebug(debugLogging),
+		WithPollPolicy(poll),
 	)
 	if err != nil {
 		return nil, err
 	}
-	wrapSecretID := sec.WrapInfo.Token
-	vc.SetWrappingLookupFunc(nil)
-
-	jsonBytes, err := json.Marshal(*job)
-	if err != nil {
-		return nil, err
-	}
-
-	debug("Encrypting the job payload")
-	data = map[string]interface{}{
-		"plaintext": base64.StdEncoding.EncodeToString(jsonBytes),
-	}
-	sec, err = vc.Logical().Write(
-		fmt.Sprintf("transit/encrypt/%s", *c.GoStintRole),
-		data,
-	)
-	if err != nil {
-		return nil, err
-	}
-	encryptedPayload := sec.Data["ciphertext"]
-
-	debug("Getting minimal limited use / ttl token for the cubbyhole")
-	data = map[string]interface{}{
-		"policies":  []string{"default"},
-		"ttl":       "60m",
-		"use_limit": 2,
-	}
-	sec, err = vc.Logical().Write("auth/token/create", data)
-	if err != nil {
-		return nil, err
-	}
-	cubbyToken := sec.Auth.ClientToken
-
-	debug("Putting encrypted payload in a vault cubbyhole")
-	vc.SetToken(cubbyToken)
-	data = map[string]interface{}{
-		"payload": encryptedPayload,
-	}
-	sec, err = vc.Logical().Write("cubbyhole/job", data)
-	if err != nil {
-		return nil, err
-	}
-
-	debug("Creating job request wrapper to submit")
-	jWrap := jobWrapper{
-		QName:        job.QName,
-		CubbyToken:   cubbyToken,
-		CubbyPath:    "cubbyhole/job",
-		WrapSecretID: wrapSecretID,
-	}
-	jWrapBytes, err := json.Marshal(jWrap)
-	if err != nil {
-		return nil, err
-	}
+	return cl.RunJob(context.Background(), c, waitFor)
+}
 
-	subResp, err := submitJob(c, &jWrapBytes, apiToken)
+// GetJob returns a job status from gostint.
+//
+// Deprecated: use the GetJob method of a Client.
+func GetJob(c *APIRequest, token string, ID string) (*GetResponse, error) {
+	cl, err := NewClient(WithRequest(c), WithVaultToken(token))
 	if err != nil {
 		return nil, err
 	}
-
-	// loop until status != queued or running
-	var getResp *GetResponse
-	for {
-		getResp, err = GetJob(c, apiToken, subResp.ID)
-		if err != nil {
-			return nil, err
-		}
-		if !waitFor {
-			break
-		}
-		if getResp.Status != "queued" && getResp.Status != "running" {
-			break
-		}
-		time.Sleep(time.Duration(pollIntervalSecs) * time.Second)
-	}
-
-	t := time.Now()
-	elapsed := t.Sub(start)
-	debug("Elapsed time: %.3f seconds", float64(elapsed/time.Millisecond)/1000.0)
-
-	return getResp, nil
+	return cl.GetJob(context.Background(), token, ID)
 }
 
 type jobWrapper struct {
-	QName        string `json:"qname"`
-	CubbyToken   string `json:"cubby_token"`
-	CubbyPath    string `json:"cubby_path"`
-	WrapSecretID string `json:"wrap_secret_id"`
+	QName          string `json:"qname"`
+	CubbyToken     string `json:"cubby_token"`
+	CubbyPath      string `json:"cubby_path"`
+	WrapSecretID   string `json:"wrap_secret_id"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
-func getContent(content *string) (*bytes.Buffer, error) {
+func getContent(content *string, debug func(string, ...interface{})) (*bytes.Buffer, error) {
 	debug("Packing content from %s", *content)
 	var buf bytes.Buffer
 	if *content == "." {
@@ -560,11 +334,21 @@ func getContent(content *string) (*bytes.Buffer, error) {
 // EncodeContent utility function to encode a folder's content as a tar.gz
 // archive and return base64 encoded to be submitted as -content parameter
 func EncodeContent(content *string) error {
+	return encodeContent(content, noDebug)
+}
+
+// EncodeContent encodes content as the package level EncodeContent, logging
+// to the client's debug output
+func (cl *Client) EncodeContent(content *string) error {
+	return encodeContent(content, cl.debug)
+}
+
+func encodeContent(content *string, debug func(string, ...interface{})) error {
 	if *content == "" {
 		return nil
 	}
 	debug("Encoding content from %s", *content)
-	buf, err := getContent(content)
+	buf, err := getContent(content, debug)
 	if err != nil {
 		return err
 	}
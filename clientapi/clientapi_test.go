@@ -0,0 +1,155 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestBuildJobZeroRequest(t *testing.T) {
+	j, err := APIRequest{}.JobSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*j, JobSpec{}) {
+		t.Errorf("got %+v, want an empty job", *j)
+	}
+}
+
+func TestBuildJobOverrides(t *testing.T) {
+	jobJSON := `{"qname": "play", "container_image": "alpine", "run": ["a"], "future": 1}`
+	image := "debian"
+	run := `["b", "c"]`
+	cont := true
+	j, err := APIRequest{JobJSON: &jobJSON, ContainerImage: &image, Run: &run, ContOnWarnings: &cont}.JobSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.QName != "play" || j.ContainerImage != "debian" || !reflect.DeepEqual(j.Run, []string{"b", "c"}) || !j.ContOnWarnings {
+		t.Errorf("got %+v", *j)
+	}
+	if _, ok := j.Extra["future"]; !ok {
+		t.Errorf("unknown field future was dropped: %+v", *j)
+	}
+
+	strict := true
+	if _, err := (APIRequest{JobJSON: &jobJSON, Strict: &strict}).JobSpec(); err == nil {
+		t.Error("strict request with an unknown field succeeded")
+	}
+}
+
+func TestBuildJobDefaults(t *testing.T) {
+	jobJSON := `{"qname": "play"}`
+	qname, defQName, defImage := "", "default", "alpine"
+	j, err := APIRequest{JobJSON: &jobJSON, QName: &qname, DefaultQName: &defQName, DefaultImage: &defImage}.JobSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.QName != "play" || j.ContainerImage != "alpine" {
+		t.Errorf("got qname %q and image %q, want play and alpine", j.QName, j.ContainerImage)
+	}
+}
+
+func TestWithClientOptions(t *testing.T) {
+	// with an http client of its own, so the tls settings aren't loaded
+	hc := WithHTTPClient(http.DefaultClient)
+	retries := 0
+	cl, err := NewClient(WithURL("https://gostint"), hc, WithClientOptions(ClientOptions{
+		VaultNamespace: "team",
+		AppRoleMount:   "/approle-ci/",
+		CACert:         "ca.pem",
+		PinSHA256:      "a,b",
+		Via:            "socks5://bastion:1080",
+		Retries:        &retries,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.vaultNS != "team" || cl.mounts.AppRole != "approle-ci" || cl.mounts.Transit != DefaultMounts().Transit {
+		t.Errorf("got namespace %q, mounts %+v", cl.vaultNS, cl.mounts)
+	}
+	if cl.tls.CACert != "ca.pem" || !reflect.DeepEqual(cl.tls.PinSHA256, []string{"a", "b"}) || cl.tls.Insecure {
+		t.Errorf("got tls %+v", cl.tls)
+	}
+	if len(cl.hops) != 1 || cl.retries.Retries != 0 || cl.retries.Interval != DefaultRetryPolicy().Interval {
+		t.Errorf("got hops %v, retries %+v", cl.hops, cl.retries)
+	}
+
+	// empty options keep what was set before
+	cl, err = NewClient(WithURL("https://gostint"), hc, WithTLS(TLSConfig{CACert: "ca.pem"}), WithClientOptions(ClientOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.tls.CACert != "ca.pem" || cl.retries != DefaultRetryPolicy() {
+		t.Errorf("got tls %+v, retries %+v", cl.tls, cl.retries)
+	}
+
+	if _, err = NewClient(WithURL("https://gostint"), WithClientOptions(ClientOptions{Via: "ftp://nope"})); err == nil {
+		t.Error("an invalid hop was accepted")
+	}
+}
+
+func TestJobCommands(t *testing.T) {
+	g := startFakeGostint(t, &fakeGostint{statuses: []string{"running"}})
+	first, second := g.addJob(""), g.addJob("")
+	cl, err := NewClient(WithURL(g.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	jobs, err := cl.ListJobs(ctx, "token", "play", "running")
+	if err != nil || len(jobs) != 2 {
+		t.Errorf("listed %v, %v, want 2 jobs", jobs, err)
+	}
+	if _, err = cl.ListJobs(ctx, "token", "", ""); err != nil {
+		t.Error(err)
+	}
+	res, err := cl.GetJob(ctx, "token", first)
+	if err != nil || res.ID != first || res.Status != "running" {
+		t.Errorf("got %+v, %v, want %s running", res, err, first)
+	}
+	if err = cl.KillJob(ctx, "token", second); err != nil {
+		t.Error(err)
+	}
+	if killed := g.Killed(); !reflect.DeepEqual(killed, []string{second}) {
+		t.Errorf("killed %v, want [%s]", killed, second)
+	}
+
+	want := []string{
+		"gostint GET /v1/api/job?qname=play&status=running",
+		"gostint GET /v1/api/job",
+		"gostint GET /v1/api/job/<id>",
+		"gostint POST /v1/api/job/kill/<id>",
+	}
+	if calls := g.rec.Calls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("called %q, want %q", calls, want)
+	}
+}
@@ -0,0 +1,322 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// CheckStatus is the outcome of a Check
+type CheckStatus string
+
+// Outcomes of a Check
+const (
+	CheckPass CheckStatus = "pass"
+	CheckWarn CheckStatus = "warn"
+	CheckFail CheckStatus = "fail"
+)
+
+// Check is the outcome of checking part of the client's setup, see Doctor
+type Check struct {
+	Name   string
+	Status CheckStatus
+	Detail string
+	Hint   string // how to put it right, unless passed
+}
+
+// cubbyholeTTL is the TTL of the token the job is passed to gostint with
+const cubbyholeTTL = 60 * time.Minute
+
+// Doctor checks the client can run jobs for the gostint role, returning the
+// outcome of each check in turn: logging in to Vault as RunJob does, looking
+// up the client's token and its TTL, the token's capabilities on every path
+// RunJob needs, the gostint role's transit key and reaching the gostint api
+// over TLS.  Checks needing a Vault login are skipped if it fails.
+func (cl *Client) Doctor(ctx context.Context, role string) []Check {
+	if role == "" {
+		role = "gostint-role"
+	}
+	checks := []Check{}
+	add := func(name string, status CheckStatus, detail, hint string) {
+		checks = append(checks, Check{Name: name, Status: status, Detail: detail, Hint: hint})
+	}
+
+	how := "token"
+	if cl.roleID != "" {
+		how = "AppRole"
+	}
+	vc, err := cl.vaultClient(ctx)
+	if err != nil {
+		add("vault login", CheckFail, vaultDetail(err), vaultHint(err, how))
+		return append(checks, cl.checkGoStint(ctx)...)
+	}
+	add("vault login", CheckPass, fmt.Sprintf("logged in to %s with %s", cl.vaultURL, how), "")
+
+	checks = append(checks, cl.checkToken(ctx, vc)...)
+	caps, capChecks := cl.checkCapabilities(ctx, vc, role)
+	checks = append(checks, capChecks...)
+	checks = append(checks, cl.checkTransitKey(ctx, vc, role, caps[cl.mounts.encryptPath(role)]))
+	return append(checks, cl.checkGoStint(ctx)...)
+}
+
+// checkToken looks up the client's token, checking its TTL allows for the
+// tokens RunJob creates from it
+func (cl *Client) checkToken(ctx context.Context, vc *api.Client) []Check {
+	sec, err := cl.vaultRead(ctx, vc, lookupSelfPath)
+	if err != nil {
+		return []Check{{Name: "token lookup", Status: CheckFail, Detail: vaultDetail(err),
+			Hint: "the token must be allowed to look itself up, as vault's default policy allows"}}
+	}
+	policies, _ := sec.TokenPolicies()
+	name, _ := sec.Data["display_name"].(string)
+	checks := []Check{{Name: "token lookup", Status: CheckPass,
+		Detail: fmt.Sprintf("%s, policies %s", name, strings.Join(policies, ", "))}}
+	for _, p := range policies {
+		if p == "root" {
+			checks[0].Status = CheckWarn
+			checks[0].Hint = "the client is using a root token, use a token or AppRole with the client's minimal policy instead"
+		}
+	}
+
+	ttl, err := sec.TokenTTL()
+	renewable, _ := sec.TokenIsRenewable()
+	switch {
+	case err != nil:
+		checks = append(checks, Check{Name: "token ttl", Status: CheckFail, Detail: err.Error()})
+	case ttl == 0:
+		checks = append(checks, Check{Name: "token ttl", Status: CheckPass, Detail: "the token does not expire"})
+	case ttl < cubbyholeTTL:
+		checks = append(checks, Check{Name: "token ttl", Status: CheckWarn,
+			Detail: fmt.Sprintf("%s left, renewable %t", ttl, renewable),
+			Hint: fmt.Sprintf("tokens the client creates can't outlive its own, so gostint must pick a job up within %s rather than %s - "+
+				"raise the token_ttl of the client's AppRole, or the ttl of its token", ttl, cubbyholeTTL)})
+	default:
+		checks = append(checks, Check{Name: "token ttl", Status: CheckPass, Detail: fmt.Sprintf("%s left, renewable %t", ttl, renewable)})
+	}
+	return checks
+}
+
+// checkCapabilities checks the client's token has the capabilities RunJob
+// needs on each path, returning the capabilities found by path
+func (cl *Client) checkCapabilities(ctx context.Context, vc *api.Client, role string) (map[string][]string, []Check) {
+	paths := []string{}
+	need := map[string][]string{}
+	for _, st := range cl.Plan(RunOptions{GoStintRole: role}) {
+		if st.Service == "vault" && len(st.Capabilities) > 0 && need[st.Path] == nil {
+			paths = append(paths, st.Path)
+			need[st.Path] = st.Capabilities
+		}
+	}
+
+	caps := map[string][]string{}
+	sec, err := cl.vaultWrite(ctx, vc, "sys/capabilities-self", map[string]interface{}{"paths": paths})
+	if err != nil {
+		return caps, []Check{{Name: "capabilities", Status: CheckFail, Detail: vaultDetail(err),
+			Hint: "the token must be allowed to update sys/capabilities-self, as vault's default policy allows"}}
+	}
+
+	checks := []Check{}
+	for _, path := range paths {
+		raw, _ := sec.Data[path].([]interface{})
+		have := map[string]bool{}
+		for _, c := range raw {
+			if s, ok := c.(string); ok {
+				caps[path] = append(caps[path], s)
+				have[s] = true
+			}
+		}
+		missing := []string{}
+		for _, c := range need[path] {
+			if !have[c] && !have["root"] {
+				missing = append(missing, c)
+			}
+		}
+		name := "capabilities " + path
+		detail := fmt.Sprintf("has [%s], needs [%s]", strings.Join(caps[path], ", "), strings.Join(need[path], ", "))
+		if len(missing) > 0 {
+			checks = append(checks, Check{Name: name, Status: CheckFail, Detail: detail,
+				Hint: fmt.Sprintf("add to a policy of the client's token, e.g. by 'gostint-client policy generate':  path \"%s\" { capabilities = [\"%s\"] }",
+					path, strings.Join(need[path], "\", \""))})
+		} else {
+			checks = append(checks, Check{Name: name, Status: CheckPass, Detail: detail})
+		}
+	}
+	return caps, checks
+}
+
+// checkTransitKey checks the transit key named after the gostint role, that
+// jobs are encrypted with, exists.  The key is read if the client may,
+// otherwise something is encrypted with it, unless that could create the key.
+func (cl *Client) checkTransitKey(ctx context.Context, vc *api.Client, role string, encryptCaps []string) Check {
+	name := "transit key " + role
+	hint := fmt.Sprintf("create the key, e.g. vault write -f %s, and allow the gostint role to decrypt with it", cl.mounts.keyPath(role))
+
+	sec, err := cl.vaultRead(ctx, vc, cl.mounts.keyPath(role))
+	switch {
+	case err == nil && sec == nil:
+		return Check{Name: name, Status: CheckFail, Detail: "not found", Hint: hint}
+	case err == nil:
+		return Check{Name: name, Status: CheckPass, Detail: fmt.Sprintf("exists, type %v, latest version %v", sec.Data["type"], sec.Data["latest_version"])}
+	case !vaultForbidden(err):
+		return Check{Name: name, Status: CheckFail, Detail: vaultDetail(err), Hint: hint}
+	}
+
+	may := map[string]bool{}
+	for _, c := range encryptCaps {
+		may[c] = true
+	}
+	switch {
+	case may["create"] || may["root"]:
+		return Check{Name: name, Status: CheckWarn,
+			Detail: "not checked, the client may not read the key and encrypting with it could create it",
+			Hint:   "the client only needs update, not create, on " + cl.mounts.encryptPath(role)}
+	case !may["update"]:
+		return Check{Name: name, Status: CheckWarn, Detail: "not checked, the client may neither read the key nor encrypt with it"}
+	}
+	data := map[string]interface{}{"plaintext": base64.StdEncoding.EncodeToString([]byte("gostint-client doctor"))}
+	if _, err = cl.vaultWrite(ctx, vc, cl.mounts.encryptPath(role), data); err != nil {
+		return Check{Name: name, Status: CheckFail, Detail: vaultDetail(err), Hint: hint}
+	}
+	return Check{Name: name, Status: CheckPass, Detail: "exists, encrypting with it succeeded"}
+}
+
+// checkGoStint checks the gostint api can be reached, and how it is secured
+func (cl *Client) checkGoStint(ctx context.Context) []Check {
+	u := strings.TrimSuffix(cl.url, "/") + jobPath
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return []Check{{Name: "gostint api", Status: CheckFail, Detail: err.Error(), Hint: "check the gostint api url"}}
+	}
+	resp, err := cl.httpClient.Do(req)
+	if err != nil {
+		return []Check{{Name: "gostint api", Status: CheckFail, Detail: err.Error(), Hint: gostintHint(err)}}
+	}
+	resp.Body.Close()
+	// unauthenticated, any response shows the api is there
+	checks := []Check{{Name: "gostint api", Status: CheckPass, Detail: fmt.Sprintf("%s answered %s", cl.url, resp.Status)}}
+
+	switch {
+	case resp.TLS == nil:
+		checks = append(checks, Check{Name: "gostint tls", Status: CheckWarn, Detail: "not using TLS",
+			Hint: "use an https url for the gostint api, the job's tokens are sent to it"})
+	case cl.tls.Insecure:
+		checks = append(checks, Check{Name: "gostint tls", Status: CheckWarn, Detail: tlsDetail(resp.TLS) + ", NOT verified",
+			Hint: "drop -insecure, trusting the gostint api's CA with -ca-cert instead"})
+	default:
+		c := Check{Name: "gostint tls", Status: CheckPass, Detail: tlsDetail(resp.TLS) + ", verified"}
+		if left := time.Until(resp.TLS.PeerCertificates[0].NotAfter); left < 30*24*time.Hour {
+			c.Status = CheckWarn
+			c.Hint = "renew the gostint api's certificate"
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// tlsDetail describes a TLS connection and the server's certificate
+func tlsDetail(cs *tls.ConnectionState) string {
+	version := map[uint16]string{
+		tls.VersionTLS10: "TLS 1.0",
+		tls.VersionTLS11: "TLS 1.1",
+		tls.VersionTLS12: "TLS 1.2",
+		tls.VersionTLS13: "TLS 1.3",
+	}[cs.Version]
+	if len(cs.PeerCertificates) == 0 {
+		return version
+	}
+	cert := cs.PeerCertificates[0]
+	return fmt.Sprintf("%s, certificate for %s issued by %s, expires %s",
+		version, certName(cert.Subject), certName(cert.Issuer), cert.NotAfter.Format("2006-01-02"))
+}
+
+// certName returns the common name of a certificate's subject or issuer,
+// else its organisation
+func certName(n pkix.Name) string {
+	if n.CommonName != "" {
+		return n.CommonName
+	}
+	return strings.Join(n.Organization, ", ")
+}
+
+// vaultDetail returns a vault error on one line
+func vaultDetail(err error) string {
+	var re *api.ResponseError
+	if errors.As(err, &re) {
+		return fmt.Sprintf("%s %s: %d %s", re.HTTPMethod, re.URL, re.StatusCode, strings.Join(re.Errors, "; "))
+	}
+	return err.Error()
+}
+
+// vaultForbidden returns true if vault refused a call for lack of permission
+func vaultForbidden(err error) bool {
+	var re *api.ResponseError
+	return errors.As(err, &re) && re.StatusCode == http.StatusForbidden
+}
+
+// vaultHint suggests how to fix a failure logging in to vault
+func vaultHint(err error, how string) string {
+	var re *api.ResponseError
+	var unknownCA x509.UnknownAuthorityError
+	switch {
+	case errors.As(err, &unknownCA):
+		return "set -vault-ca-cert, or VAULT_CACERT, to the CA bundle that signed vault's certificate"
+	case errors.As(err, &re) && (re.StatusCode == http.StatusBadRequest || re.StatusCode == http.StatusForbidden):
+		if how == "AppRole" {
+			return "check the AppRole's role id and secret id, and that the secret id has not expired or run out of uses"
+		}
+		return "check the vault token is valid and has not expired"
+	case errors.As(err, &re):
+		return "check vault is unsealed and healthy"
+	}
+	return "check the vault url, and -vault-via if vault is reached through hops"
+}
+
+// gostintHint suggests how to fix a failure reaching the gostint api
+func gostintHint(err error) string {
+	var unknownCA x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	switch {
+	case errors.As(err, &unknownCA):
+		return "set -ca-cert to the CA bundle that signed the gostint api's certificate"
+	case errors.As(err, &hostname):
+		return "use the host name in the gostint api's certificate, or set -tls-server-name"
+	case strings.Contains(err.Error(), "pin"):
+		return "check -pin-sha256 against the gostint api's current public key"
+	}
+	return "check the gostint api url, and -via if it is reached through hops"
+}
@@ -0,0 +1,167 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// doctorVault answers the calls Doctor makes for a token with the policies
+// and ttl, capabilities on each path of the client's plan, and, if the key
+// status is not 0, reading the transit key
+func doctorVault(policies string, ttl int, caps func(path string) []string, keyStatus int) func(string, http.ResponseWriter, *http.Request) bool {
+	return func(path string, w http.ResponseWriter, r *http.Request) bool {
+		switch {
+		case path == lookupSelfPath:
+			fmt.Fprintf(w, `{"data": {"display_name": "token-client", "policies": [%s], "ttl": %d, "renewable": true}}`, policies, ttl)
+		case path == "sys/capabilities-self":
+			var req struct{ Paths []string }
+			json.NewDecoder(r.Body).Decode(&req)
+			data := map[string][]string{}
+			for _, p := range req.Paths {
+				data[p] = caps(p)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		case strings.HasPrefix(path, "transit/keys/") && keyStatus == http.StatusOK:
+			w.Write([]byte(`{"data": {"type": "aes256-gcm96", "latest_version": 1}}`))
+		case strings.HasPrefix(path, "transit/keys/") && keyStatus != 0:
+			w.WriteHeader(keyStatus)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+		default:
+			return false
+		}
+		return true
+	}
+}
+
+// checkStatuses returns the status of each check by name
+func checkStatuses(checks []Check) map[string]CheckStatus {
+	statuses := map[string]CheckStatus{}
+	for _, c := range checks {
+		statuses[c.Name] = c.Status
+	}
+	return statuses
+}
+
+func TestDoctor(t *testing.T) {
+	update := func(string) []string { return []string{"update"} }
+	for _, tc := range []struct {
+		name    string
+		vault   *fakeVault
+		appRole bool
+		want    map[string]CheckStatus
+	}{{
+		name:  "healthy",
+		vault: &fakeVault{answer: doctorVault(`"client"`, 7200, update, http.StatusOK)},
+		want: map[string]CheckStatus{
+			"vault login":                    CheckPass,
+			"token lookup":                   CheckPass,
+			"token ttl":                      CheckPass,
+			"capabilities auth/token/create": CheckPass,
+			"capabilities auth/approle/role/gostint-role/secret-id": CheckPass,
+			"capabilities transit/encrypt/gostint-role":             CheckPass,
+			"transit key gostint-role":                              CheckPass,
+			"gostint api":                                           CheckPass,
+			"gostint tls":                                           CheckWarn,
+		},
+	}, {
+		name: "root token expiring, missing a capability",
+		vault: &fakeVault{answer: doctorVault(`"root"`, 60, func(path string) []string {
+			if strings.HasSuffix(path, "/secret-id") {
+				return []string{"read"}
+			}
+			return []string{"create", "update"}
+		}, http.StatusForbidden)},
+		want: map[string]CheckStatus{
+			"vault login":                    CheckPass,
+			"token lookup":                   CheckWarn,
+			"token ttl":                      CheckWarn,
+			"capabilities auth/token/create": CheckPass,
+			"capabilities auth/approle/role/gostint-role/secret-id": CheckFail,
+			"capabilities transit/encrypt/gostint-role":             CheckPass,
+			"transit key gostint-role":                              CheckWarn,
+			"gostint api":                                           CheckPass,
+			"gostint tls":                                           CheckWarn,
+		},
+	}, {
+		name:  "key found by encrypting",
+		vault: &fakeVault{answer: doctorVault(`"client"`, 0, update, http.StatusForbidden)},
+		want: map[string]CheckStatus{
+			"vault login":                    CheckPass,
+			"token lookup":                   CheckPass,
+			"token ttl":                      CheckPass,
+			"capabilities auth/token/create": CheckPass,
+			"capabilities auth/approle/role/gostint-role/secret-id": CheckPass,
+			"capabilities transit/encrypt/gostint-role":             CheckPass,
+			"transit key gostint-role":                              CheckPass,
+			"gostint api":                                           CheckPass,
+			"gostint tls":                                           CheckWarn,
+		},
+	}, {
+		name: "approle login refused",
+		vault: &fakeVault{fail: func(path string, n int) int {
+			if strings.HasSuffix(path, "/login") {
+				return http.StatusBadRequest
+			}
+			return 0
+		}},
+		appRole: true,
+		want: map[string]CheckStatus{
+			"vault login": CheckFail,
+			"gostint api": CheckPass,
+			"gostint tls": CheckWarn,
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			vault := startFakeVault(t, tc.vault)
+			gostint := startFakeGostint(t, &fakeGostint{})
+			auth := WithVaultToken("client")
+			if tc.appRole {
+				auth = WithAppRole("role-id", "secret-id")
+			}
+			cl, err := NewClient(WithURL(gostint.URL), WithVaultURL(vault.URL), auth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checks := cl.Doctor(context.Background(), "")
+			if got := checkStatuses(checks); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got checks %v, want %v", got, tc.want)
+			}
+			for _, c := range checks {
+				if c.Status != CheckPass && c.Hint == "" {
+					t.Errorf("check %s %s without a hint: %s", c.Name, c.Status, c.Detail)
+				}
+			}
+		})
+	}
+}
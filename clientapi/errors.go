@@ -0,0 +1,122 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors an APIError matches with errors.Is, by its status code
+var (
+	ErrUnauthorized = errors.New("not authorised by gostint") // 401 or 403
+	ErrNotFound     = errors.New("not found in gostint")      // 404
+	ErrServer       = errors.New("gostint server error")      // 5xx
+)
+
+// APIError is a non-2xx response from the gostint api
+type APIError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Message    string // the server's explanation, if it gave one
+	RequestID  string // the server's id for the request, if it gave one
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("gostint api %s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request id %s)", e.RequestID)
+	}
+	return msg
+}
+
+// Is matches the sentinel errors ErrUnauthorized, ErrNotFound and ErrServer
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// maxErrorMessage limits how much of a non-json error body is reported
+const maxErrorMessage = 512
+
+// newAPIError builds the error for a non-2xx response and its body
+func newAPIError(method, path string, resp *http.Response, body []byte) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     method,
+		Path:       path,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	for _, h := range []string{"X-Request-Id", "X-Correlation-Id", "Request-Id"} {
+		if id := resp.Header.Get(h); id != "" {
+			e.RequestID = id
+			break
+		}
+	}
+
+	var msg struct {
+		Error     string   `json:"error"`
+		Message   string   `json:"message"`
+		Errors    []string `json:"errors"`
+		RequestID string   `json:"request_id"`
+	}
+	if json.Unmarshal(body, &msg) == nil {
+		switch {
+		case msg.Error != "":
+			e.Message = msg.Error
+		case msg.Message != "":
+			e.Message = msg.Message
+		case len(msg.Errors) > 0:
+			e.Message = strings.Join(msg.Errors, "; ")
+		}
+		if e.RequestID == "" {
+			e.RequestID = msg.RequestID
+		}
+		return e
+	}
+	e.Message = strings.TrimSpace(string(body))
+	if len(e.Message) > maxErrorMessage {
+		e.Message = e.Message[:maxErrorMessage] + "..."
+	}
+	return e
+}
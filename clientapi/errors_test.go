@@ -0,0 +1,102 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIError(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		status  int
+		header  http.Header
+		body    string
+		is      error // nil for none of the sentinels
+		message string
+		reqID   string
+	}{
+		{"unauthorised", 401, nil, `{"error": "bad token"}`, ErrUnauthorized, "bad token", ""},
+		{"forbidden", 403, nil, `{"message": "denied"}`, ErrUnauthorized, "denied", ""},
+		{"not found", 404, http.Header{"X-Request-Id": {"r-1"}}, `{"errors": ["no job", "really"]}`, ErrNotFound, "no job; really", "r-1"},
+		{"server error", 500, nil, `{"error": "boom", "request_id": "r-2"}`, ErrServer, "boom", "r-2"},
+		{"bad request", 400, nil, "not json\n", nil, "not json", ""},
+		{"long body", 502, nil, strings.Repeat("x", 600), ErrServer, strings.Repeat("x", maxErrorMessage) + "...", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tc.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+			cl, err := NewClient(WithURL(srv.URL), WithRetryPolicy(RetryPolicy{Multiplier: 1}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = cl.GetJob(context.Background(), "token", "job-1")
+			var ae *APIError
+			if !errors.As(err, &ae) {
+				t.Fatalf("got %v, want an APIError", err)
+			}
+			if ae.StatusCode != tc.status || ae.Message != tc.message || ae.RequestID != tc.reqID {
+				t.Errorf("got %d %q request id %q, want %d %q %q", ae.StatusCode, ae.Message, ae.RequestID, tc.status, tc.message, tc.reqID)
+			}
+			for _, sentinel := range []error{ErrUnauthorized, ErrNotFound, ErrServer} {
+				if got := errors.Is(err, sentinel); got != (sentinel == tc.is) {
+					t.Errorf("errors.Is(%v) = %v", sentinel, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIErrorRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	cl, err := NewClient(WithURL(srv.URL), WithRetryPolicy(RetryPolicy{Multiplier: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cl.GetJob(context.Background(), "token", "job-1")
+	var ae *APIError
+	if !errors.As(err, &ae) || ae.RetryAfter != 2*time.Minute {
+		t.Errorf("got %v, want a Retry-After of 2m", err)
+	}
+}
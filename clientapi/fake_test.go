@@ -0,0 +1,316 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder records the calls made to fake Vault and gostint servers as
+// "service METHOD path"
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (rec *recorder) record(service, method, path string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	// vault treats PUT as POST, and polling repeats a call
+	if method == "PUT" {
+		method = "POST"
+	}
+	call := fmt.Sprintf("%s %s %s", service, method, path)
+	if n := len(rec.calls); n > 0 && rec.calls[n-1] == call {
+		return
+	}
+	rec.calls = append(rec.calls, call)
+}
+
+// Calls returns the calls recorded so far
+func (rec *recorder) Calls() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]string{}, rec.calls...)
+}
+
+// fakeVault is a Vault answering the calls the client makes to run a job.
+// Each token it creates is new, token-1, token-2 and so on.
+type fakeVault struct {
+	*httptest.Server
+	rec *recorder
+
+	// fail, if set, returns the status to fail the nth call, from 1, to a
+	// path with, or 0 to answer it
+	fail func(path string, n int) int
+
+	// retryAfter, if set, is the Retry-After sent with failures
+	retryAfter string
+
+	// answer, if set, is called first with each call not failed, returning
+	// true if it answered the call
+	answer func(path string, w http.ResponseWriter, r *http.Request) bool
+
+	mu      sync.Mutex
+	counts  map[string]int
+	tokens  int
+	revoked []string
+}
+
+// startFakeVault starts v, closing it when the test ends
+func startFakeVault(t *testing.T, v *fakeVault) *fakeVault {
+	if v.rec == nil {
+		v.rec = &recorder{}
+	}
+	v.counts = map[string]int{}
+	v.Server = httptest.NewServer(v)
+	t.Cleanup(v.Close)
+	return v
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	v.rec.record("vault", r.Method, path)
+	v.mu.Lock()
+	v.counts[path]++
+	status := 0
+	if v.fail != nil {
+		status = v.fail(path, v.counts[path])
+	}
+	v.mu.Unlock()
+	if status != 0 {
+		if v.retryAfter != "" {
+			w.Header().Set("Retry-After", v.retryAfter)
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"errors": ["failed %s"]}`, path)
+		return
+	}
+	if v.answer != nil && v.answer(path, w, r) {
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(path, "/login"), path == tokenCreatePath:
+		v.mu.Lock()
+		v.tokens++
+		token := fmt.Sprintf("token-%d", v.tokens)
+		v.mu.Unlock()
+		fmt.Fprintf(w, `{"auth": {"client_token": %q}}`, token)
+	case path == revokeSelfPath:
+		v.mu.Lock()
+		v.revoked = append(v.revoked, r.Header.Get("X-Vault-Token"))
+		v.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case path == lookupSelfPath:
+		w.Write([]byte(`{"data": {}}`))
+	case strings.HasSuffix(path, "/secret-id"):
+		w.Write([]byte(`{"wrap_info": {"token": "wrapped"}}`))
+	case strings.Contains(path, "/encrypt/"):
+		w.Write([]byte(`{"data": {"ciphertext": "vault:v1:abc"}}`))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Revoked returns the tokens revoked so far
+func (v *fakeVault) Revoked() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]string{}, v.revoked...)
+}
+
+// fakeGostint is a gostint API.  Jobs submitted are job-1, job-2 and so on,
+// a submission with the idempotency key of an earlier one answered with its
+// job, and can be looked up by key.
+type fakeGostint struct {
+	*httptest.Server
+	rec *recorder
+
+	// statuses each job reports when polled, in turn, staying in the last.
+	// "" fails the poll with a 503.  Jobs succeed at once if not set.
+	statuses []string
+	output   string
+
+	// submit, if set, is called with the count of a submission, from 1, once
+	// its job is accepted, returning true if it answered the submission
+	submit func(n int, w http.ResponseWriter, r *http.Request) bool
+
+	// stream, if set, answers requests for a job's output stream
+	stream func(w http.ResponseWriter, r *http.Request)
+
+	// tls, if set, serves https with this configuration, over the httptest
+	// certificate for 127.0.0.1 if it has none
+	tls *tls.Config
+
+	mu      sync.Mutex
+	jobs    map[string]*fakeJob
+	keys    map[string]string // idempotency key to job id
+	submits int
+	killed  []string
+}
+
+type fakeJob struct {
+	key   string
+	polls int
+}
+
+// startFakeGostint starts g, closing it when the test ends
+func startFakeGostint(t *testing.T, g *fakeGostint) *fakeGostint {
+	if g.rec == nil {
+		g.rec = &recorder{}
+	}
+	g.jobs = map[string]*fakeJob{}
+	g.keys = map[string]string{}
+	g.Server = httptest.NewUnstartedServer(g)
+	// failed handshakes are expected, and reported to the client
+	g.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	if g.tls != nil {
+		g.Server.TLS = g.tls
+		g.StartTLS()
+	} else {
+		g.Start()
+	}
+	t.Cleanup(g.Close)
+	return g
+}
+
+var jobIDRe = regexp.MustCompile(`job-[0-9]+`)
+
+// addJob adds a job, as if submitted with the idempotency key, returning its
+// id
+func (g *fakeGostint) addJob(key string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.newJob(key)
+}
+
+// newJob is addJob, with mu held
+func (g *fakeGostint) newJob(key string) string {
+	ID := fmt.Sprintf("job-%d", len(g.jobs)+1)
+	g.jobs[ID] = &fakeJob{key: key}
+	if key != "" {
+		g.keys[key] = ID
+	}
+	return ID
+}
+
+func (g *fakeGostint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.rec.record("gostint", r.Method, jobIDRe.ReplaceAllString(r.URL.RequestURI(), "<id>"))
+	// a client's disconnect is only noticed once the body has been read
+	ioutil.ReadAll(r.Body)
+
+	ID := strings.TrimPrefix(r.URL.Path, jobPath+"/")
+	switch {
+	case r.Method == "POST" && r.URL.Path == jobPath:
+		key := r.Header.Get("Idempotency-Key")
+		g.mu.Lock()
+		g.submits++
+		n := g.submits
+		ID, ok := g.keys[key]
+		if !ok || key == "" {
+			ID = g.newJob(key)
+		}
+		g.mu.Unlock()
+		if g.submit != nil && g.submit(n, w, r) {
+			return
+		}
+		fmt.Fprintf(w, `{"_id": %q, "status": "queued"}`, ID)
+	case r.Method == "GET" && r.URL.Path == jobPath:
+		key := r.URL.Query().Get("idempotency_key")
+		g.mu.Lock()
+		jobs := []GetResponse{}
+		for ID, j := range g.jobs {
+			if key == "" || j.key == key {
+				jobs = append(jobs, GetResponse{ID: ID, Status: "queued", IdempotencyKey: j.key})
+			}
+		}
+		g.mu.Unlock()
+		json.NewEncoder(w).Encode(jobs)
+	case r.Method == "POST" && strings.HasPrefix(r.URL.Path, jobPath+"/kill/"):
+		g.mu.Lock()
+		g.killed = append(g.killed, strings.TrimPrefix(r.URL.Path, jobPath+"/kill/"))
+		g.mu.Unlock()
+		w.Write([]byte(`{}`))
+	case r.Method == "GET" && strings.HasSuffix(ID, "/stream") && g.stream != nil:
+		g.stream(w, r)
+	case r.Method == "GET" && g.job(ID) != nil:
+		status := g.poll(ID)
+		if status == "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(GetResponse{ID: ID, Status: status, Output: g.output, IdempotencyKey: g.job(ID).key})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (g *fakeGostint) job(ID string) *fakeJob {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.jobs[ID]
+}
+
+// poll returns the status of a job for this poll of it
+func (g *fakeGostint) poll(ID string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	j := g.jobs[ID]
+	j.polls++
+	switch {
+	case len(g.statuses) == 0:
+		return "success"
+	case j.polls > len(g.statuses):
+		return g.statuses[len(g.statuses)-1]
+	}
+	return g.statuses[j.polls-1]
+}
+
+// Submits returns the number of job submissions made
+func (g *fakeGostint) Submits() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.submits
+}
+
+// Killed returns the ids of the jobs killed
+func (g *fakeGostint) Killed() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string{}, g.killed...)
+}
@@ -0,0 +1,468 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+// Hop is an intermediary that connections to gostint or vault are routed via,
+// parsed from a url:
+//
+//	http://[user:pass@]proxy:3128          - HTTP CONNECT proxy
+//	https://[user:pass@]proxy:3128[?ca=...] - HTTP CONNECT proxy over TLS
+//	socks5://[user:pass@]bastion:1080      - SOCKS5 proxy
+//	ssh://user@jumphost:22[?key=...]       - SSH jump host (direct-tcpip)
+//
+// SSH hops authenticate with the ssh-agent (SSH_AUTH_SOCK) and/or the private
+// key file given in the key query parameter, and verify the host key against
+// ~/.ssh/known_hosts unless known_hosts=path or insecure=true are given.
+// HTTPS hops verify the proxy's certificate against the system roots, or the
+// PEM bundle given in the ca query parameter, unless insecure=true is given.
+type Hop struct {
+	URL *url.URL
+}
+
+func (h Hop) String() string {
+	u := *h.URL
+	if u.User != nil {
+		u.User = url.User(u.User.Username())
+	}
+	return u.String()
+}
+
+// ParseHops parses a comma separated list of hop urls, in the order they are
+// to be traversed, e.g. "http://gw:3128,ssh://me@bastion"
+func ParseHops(spec string) ([]Hop, error) {
+	hops := []Hop{}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hop '%s': %s", s, err)
+		}
+		switch u.Scheme {
+		case "http", "https":
+			if u.Port() == "" {
+				u.Host = net.JoinHostPort(u.Hostname(), "3128")
+			}
+		case "socks5", "socks5h":
+			if u.Port() == "" {
+				u.Host = net.JoinHostPort(u.Hostname(), "1080")
+			}
+		case "ssh":
+			if u.Port() == "" {
+				u.Host = net.JoinHostPort(u.Hostname(), "22")
+			}
+			if u.User == nil || u.User.Username() == "" {
+				return nil, fmt.Errorf("ssh hop '%s' must specify a user, e.g. ssh://user@host", s)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported hop scheme '%s' in '%s', must be http, https, socks5 or ssh", u.Scheme, s)
+		}
+		hops = append(hops, Hop{URL: u})
+	}
+	return hops, nil
+}
+
+// WithHops routes connections to the gostint api via the hops, in order
+func WithHops(hops []Hop) Option {
+	return func(cl *Client) error {
+		cl.hops = hops
+		return nil
+	}
+}
+
+// WithVaultHops routes connections to vault via the hops, in order
+func WithVaultHops(hops []Hop) Option {
+	return func(cl *Client) error {
+		cl.vaultHops = hops
+		return nil
+	}
+}
+
+// contextDialer is satisfied by net.Dialer and each of the hop dialers
+type contextDialer interface {
+	Dial(network, addr string) (net.Conn, error)
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// chainDialer returns a dialer that tunnels through each hop in turn
+func chainDialer(hops []Hop) (contextDialer, error) {
+	var d contextDialer = &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	for _, h := range hops {
+		switch h.URL.Scheme {
+		case "http":
+			d = &connectDialer{hop: h, forward: d}
+		case "https":
+			cfg, err := hopTLSConfig(h)
+			if err != nil {
+				return nil, err
+			}
+			d = &connectDialer{hop: h, forward: d, tls: cfg}
+		case "socks5", "socks5h":
+			var auth *proxy.Auth
+			if h.URL.User != nil {
+				pass, _ := h.URL.User.Password()
+				auth = &proxy.Auth{User: h.URL.User.Username(), Password: pass}
+			}
+			sd, err := proxy.SOCKS5("tcp", h.URL.Host, auth, d)
+			if err != nil {
+				return nil, err
+			}
+			d = &socksDialer{Dialer: sd}
+		case "ssh":
+			cfg, agent, err := sshClientConfig(h)
+			if err != nil {
+				return nil, err
+			}
+			d = &sshDialer{hop: h, config: cfg, agent: agent, forward: d}
+		}
+	}
+	return d, nil
+}
+
+// setHops configures a transport to dial via the hops, if any
+func setHops(tr *http.Transport, hops []Hop) error {
+	if len(hops) == 0 {
+		return nil
+	}
+	d, err := chainDialer(hops)
+	if err != nil {
+		return err
+	}
+	// the hops replace any proxy from the environment
+	tr.Proxy = nil
+	tr.DialContext = d.DialContext
+	return nil
+}
+
+// connectDialer tunnels via an HTTP CONNECT proxy, over TLS if tls is set
+type connectDialer struct {
+	hop     Hop
+	forward contextDialer
+	tls     *tls.Config
+}
+
+func (d *connectDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *connectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, "tcp", d.hop.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if d.tls != nil {
+		tlsConn := tls.Client(conn, d.tls)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s: %s", d.hop, err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if d.hop.URL.User != nil {
+		pass, _ := d.hop.URL.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(d.hop.URL.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused CONNECT to %s: %s", d.hop, addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s sent unexpected data after CONNECT", d.hop)
+	}
+	return conn, nil
+}
+
+// hopTLSConfig returns the tls config to connect to an https hop
+func hopTLSConfig(h Hop) (*tls.Config, error) {
+	q := h.URL.Query()
+	cfg := &tls.Config{
+		ServerName:         h.URL.Hostname(),
+		InsecureSkipVerify: q.Get("insecure") == "true",
+	}
+	if caFile := q.Get("ca"); caFile != "" {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("https hop %s ca %s: no certificates found", h, caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// socksDialer adds a context to the SOCKS5 dialer from x/net/proxy
+type socksDialer struct {
+	proxy.Dialer
+}
+
+func (d *socksDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if cd, ok := d.Dialer.(proxy.ContextDialer); ok {
+		return cd.DialContext(ctx, network, addr)
+	}
+	return d.Dialer.Dial(network, addr)
+}
+
+// sshDialer tunnels via an SSH jump host, the ssh connection is kept open and
+// shared by all connections through this hop
+type sshDialer struct {
+	hop     Hop
+	config  *ssh.ClientConfig
+	agent   *sshAgent // nil without SSH_AUTH_SOCK
+	forward contextDialer
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+func (d *sshDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *sshDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := d.sshClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sshDial(ctx, client, network, addr)
+	if err != nil {
+		if _, ok := err.(*ssh.OpenChannelError); ok || ctx.Err() != nil {
+			// the jump host refused the target, e.g. nothing listening on its
+			// port, or ctx ended the dial, the ssh connection itself is fine
+			return nil, err
+		}
+		// the jump host connection may have dropped, reconnect once
+		d.reset(client)
+		if client, err = d.sshClient(ctx); err != nil {
+			return nil, err
+		}
+		return sshDial(ctx, client, network, addr)
+	}
+	return conn, nil
+}
+
+// sshDial opens a connection to addr through the jump host, giving up once
+// ctx is done, as ssh.Client.Dial has no context of its own
+func sshDial(ctx context.Context, client *ssh.Client, network, addr string) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := client.Dial(network, addr)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		// close the connection if the dial completes after all
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+func (d *sshDialer) sshClient(ctx context.Context) (*ssh.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	conn, err := d.forward.DialContext(ctx, "tcp", d.hop.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	// the handshake must not outlast ctx, it has no context of its own
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if d.agent != nil {
+		defer d.agent.close()
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, d.hop.URL.Host, d.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh hop %s: %s", d.hop, err)
+	}
+	conn.SetDeadline(time.Time{})
+	d.client = ssh.NewClient(c, chans, reqs)
+	return d.client, nil
+}
+
+func (d *sshDialer) reset(client *ssh.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client == client {
+		d.client.Close()
+		d.client = nil
+	}
+}
+
+// sshAgent is the ssh-agent at SSH_AUTH_SOCK, connected to only while
+// authenticating with a jump host
+type sshAgent struct {
+	sock string
+	conn net.Conn
+}
+
+// signers connects to the agent and returns its keys, which sign with that
+// connection, so it is kept until close.  An agent that can't be reached
+// offers no keys, leaving the other auth methods to be tried.
+func (a *sshAgent) signers() ([]ssh.Signer, error) {
+	a.close()
+	conn, err := net.Dial("unix", a.sock)
+	if err != nil {
+		return nil, nil
+	}
+	a.conn = conn
+	return agent.NewClient(conn).Signers()
+}
+
+// close closes the agent connection once authentication is done
+func (a *sshAgent) close() {
+	if a.conn != nil {
+		a.conn.Close()
+		a.conn = nil
+	}
+}
+
+// sshClientConfig returns the ssh configuration for a jump host, and the
+// ssh-agent it authenticates with, if any
+func sshClientConfig(h Hop) (*ssh.ClientConfig, *sshAgent, error) {
+	q := h.URL.Query()
+	auths := []ssh.AuthMethod{}
+	var sa *sshAgent
+
+	if keyFile := q.Get("key"); keyFile != "" {
+		b, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ssh hop %s key %s: %s", h, keyFile, err)
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		sa = &sshAgent{sock: sock}
+		auths = append(auths, ssh.PublicKeysCallback(sa.signers))
+	}
+	if pass, ok := h.URL.User.Password(); ok {
+		auths = append(auths, ssh.Password(pass))
+	}
+	if len(auths) == 0 {
+		return nil, nil, fmt.Errorf("ssh hop %s has no authentication, set SSH_AUTH_SOCK or key=path", h)
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	if q.Get("insecure") == "true" {
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		khFile := q.Get("known_hosts")
+		if khFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, nil, err
+			}
+			khFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		cb, err := knownhosts.New(khFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ssh hop %s known_hosts: %s", h, err)
+		}
+		hostKeyCallback = cb
+	}
+
+	return &ssh.ClientConfig{
+		User:            h.URL.User.Username(),
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}, sa, nil
+}
@@ -0,0 +1,613 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// echoServer stands in for gostint or vault, echoing back what it is sent
+func echoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// closedAddr returns an address with nothing listening on it
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func pipe(a, b net.Conn) {
+	defer a.Close()
+	defer b.Close()
+	go io.Copy(a, b)
+	io.Copy(b, a)
+}
+
+// connectProxy is an HTTP CONNECT proxy, requiring basic auth if user is set
+func connectProxy(user, pass string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		if user != "" {
+			u, p, ok := (&http.Request{Header: http.Header{
+				"Authorization": r.Header["Proxy-Authorization"],
+			}}).BasicAuth()
+			if !ok || u != user || p != pass {
+				w.WriteHeader(http.StatusProxyAuthRequired)
+				return
+			}
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		pipe(conn, target)
+	})
+}
+
+// socks5Server is a SOCKS5 proxy supporting CONNECT, with username/password
+// auth if user is set
+func socks5Server(t *testing.T, user, pass string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				target, err := socks5Handshake(conn, user, pass)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				pipe(conn, target)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func socks5Handshake(conn net.Conn, user, pass string) (net.Conn, error) {
+	br := bufio.NewReader(conn)
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return nil, err
+	}
+	if user == "" {
+		conn.Write([]byte{5, 0})
+	} else {
+		conn.Write([]byte{5, 2})
+		// RFC 1929: ver, ulen, uname, plen, passwd
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, err
+		}
+		u := make([]byte, b[1])
+		io.ReadFull(br, u)
+		plen, _ := br.ReadByte()
+		p := make([]byte, plen)
+		io.ReadFull(br, p)
+		if string(u) != user || string(p) != pass {
+			conn.Write([]byte{1, 1})
+			return nil, fmt.Errorf("bad credentials")
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(br, req); err != nil {
+		return nil, err
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(br, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n, _ := br.ReadByte()
+		name := make([]byte, n)
+		io.ReadFull(br, name)
+		host = string(name)
+	case 4:
+		ip := make([]byte, 16)
+		io.ReadFull(br, ip)
+		host = net.IP(ip).String()
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(br, port); err != nil {
+		return nil, err
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return target, nil
+}
+
+// sshServer is an SSH jump host allowing direct-tcpip channels, it returns
+// its address, a known_hosts file for it and a count of ssh connections made
+func sshServer(t *testing.T, user, pass string) (string, string, *int32) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if c.User() == user && string(p) == pass {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("denied")
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	conns := new(int32)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				atomic.AddInt32(conns, 1)
+				go ssh.DiscardRequests(reqs)
+				for nc := range chans {
+					go sshForward(nc)
+				}
+			}()
+		}
+	}()
+
+	kh := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(l.Addr().String())}, signer.PublicKey())
+	if err := os.WriteFile(kh, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return l.Addr().String(), kh, conns
+}
+
+// stallHost is a target the jump host takes 2 seconds to refuse
+const stallHost = "stall.invalid"
+
+func sshForward(nc ssh.NewChannel) {
+	if nc.ChannelType() != "direct-tcpip" {
+		nc.Reject(ssh.UnknownChannelType, nc.ChannelType())
+		return
+	}
+	var req struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &req); err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	if req.Host == stallHost {
+		time.Sleep(2 * time.Second)
+		nc.Reject(ssh.ConnectionFailed, "stalled")
+		return
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(req.Host, strconv.Itoa(int(req.Port))))
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	defer ch.Close()
+	defer target.Close()
+	go io.Copy(ch, target)
+	io.Copy(target, ch)
+}
+
+func mustHops(t *testing.T, spec string) []Hop {
+	t.Helper()
+	hops, err := ParseHops(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hops
+}
+
+// roundTrip dials addr via d and checks the echo server answers
+func roundTrip(t *testing.T, d contextDialer, addr string) {
+	t.Helper()
+	conn, err := d.DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial %s: %s", addr, err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "ping\n" {
+		t.Fatalf("got %q, want %q", line, "ping\n")
+	}
+}
+
+func TestParseHops(t *testing.T) {
+	hops := mustHops(t, "http://gw, socks5://bastion ,ssh://me@jump,https://gw2:8443")
+	want := []string{"http://gw:3128", "socks5://bastion:1080", "ssh://me@jump:22", "https://gw2:8443"}
+	if len(hops) != len(want) {
+		t.Fatalf("got %d hops, want %d", len(hops), len(want))
+	}
+	for i, h := range hops {
+		if h.String() != want[i] {
+			t.Errorf("hop %d: got %s, want %s", i, h, want[i])
+		}
+	}
+	if h := mustHops(t, "http://user:secret@gw")[0]; strings.Contains(h.String(), "secret") {
+		t.Errorf("hop %s shows its password", h)
+	}
+
+	for _, spec := range []string{"ftp://gw", "ssh://jump"} {
+		if _, err := ParseHops(spec); err == nil {
+			t.Errorf("ParseHops(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestConnectHop(t *testing.T) {
+	target := echoServer(t)
+	proxy := httptest.NewServer(connectProxy("", ""))
+	defer proxy.Close()
+
+	d, err := chainDialer(mustHops(t, proxy.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, d, target)
+
+	if _, err := d.DialContext(context.Background(), "tcp", closedAddr(t)); err == nil ||
+		!strings.Contains(err.Error(), "refused CONNECT") {
+		t.Errorf("dial to a closed port: got %v, want a refused CONNECT error", err)
+	}
+}
+
+func TestConnectHopAuth(t *testing.T) {
+	target := echoServer(t)
+	proxy := httptest.NewServer(connectProxy("me", "secret"))
+	defer proxy.Close()
+	u, _ := url.Parse(proxy.URL)
+
+	u.User = url.UserPassword("me", "secret")
+	d, err := chainDialer(mustHops(t, u.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, d, target)
+
+	u.User = url.UserPassword("me", "wrong")
+	d, err = chainDialer(mustHops(t, u.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DialContext(context.Background(), "tcp", target); err == nil ||
+		!strings.Contains(err.Error(), "407") {
+		t.Errorf("got %v, want a 407 error", err)
+	}
+}
+
+func TestConnectHopTLS(t *testing.T) {
+	target := echoServer(t)
+	proxy := httptest.NewUnstartedServer(connectProxy("", ""))
+	proxy.Config.ErrorLog = log.New(io.Discard, "", 0)
+	proxy.StartTLS()
+	defer proxy.Close()
+
+	// not trusted by the system roots
+	d, err := chainDialer(mustHops(t, proxy.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DialContext(context.Background(), "tcp", target); err == nil {
+		t.Error("dial via an untrusted https proxy succeeded")
+	}
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: proxy.Certificate().Raw})
+	if err := os.WriteFile(ca, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// the httptest certificate is for example.com and 127.0.0.1
+	d, err = chainDialer(mustHops(t, proxy.URL+"?ca="+url.QueryEscape(ca)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, d, target)
+
+	d, err = chainDialer(mustHops(t, proxy.URL+"?insecure=true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, d, target)
+
+	// a plain http hop to a TLS proxy must not work
+	if d, err = chainDialer(mustHops(t, strings.Replace(proxy.URL, "https:", "http:", 1))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DialContext(context.Background(), "tcp", target); err == nil {
+		t.Error("dial via http to an https proxy succeeded")
+	}
+}
+
+func TestSOCKS5Hop(t *testing.T) {
+	target := echoServer(t)
+
+	d, err := chainDialer(mustHops(t, "socks5://"+socks5Server(t, "", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, d, target)
+	if _, err := d.DialContext(context.Background(), "tcp", closedAddr(t)); err == nil {
+		t.Error("dial to a closed port via socks5 succeeded")
+	}
+
+	addr := socks5Server(t, "me", "secret")
+	d, err = chainDialer(mustHops(t, "socks5://me:secret@"+addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, d, target)
+
+	d, err = chainDialer(mustHops(t, "socks5://me:wrong@"+addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DialContext(context.Background(), "tcp", target); err == nil {
+		t.Error("dial via socks5 with a wrong password succeeded")
+	}
+}
+
+func TestSSHHop(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	target := echoServer(t)
+	addr, kh, conns := sshServer(t, "me", "secret")
+	hop := "ssh://me:secret@" + addr + "?known_hosts=" + url.QueryEscape(kh)
+
+	d, err := chainDialer(mustHops(t, hop))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, d, target)
+	roundTrip(t, d, target)
+	if n := atomic.LoadInt32(conns); n != 1 {
+		t.Errorf("got %d ssh connections, want 1 shared", n)
+	}
+
+	// a refused target must not drop the shared ssh connection
+	if _, err := d.DialContext(context.Background(), "tcp", closedAddr(t)); err == nil {
+		t.Error("dial to a closed port via ssh succeeded")
+	}
+	roundTrip(t, d, target)
+	if n := atomic.LoadInt32(conns); n != 1 {
+		t.Errorf("got %d ssh connections after a refused target, want 1", n)
+	}
+
+	// reconnects once the ssh connection drops
+	sd := d.(*sshDialer)
+	sd.mu.Lock()
+	sd.client.Close()
+	sd.mu.Unlock()
+	roundTrip(t, d, target)
+	if n := atomic.LoadInt32(conns); n != 2 {
+		t.Errorf("got %d ssh connections after a dropped connection, want 2", n)
+	}
+}
+
+func TestSSHHopHostKey(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	target := echoServer(t)
+	addr, _, _ := sshServer(t, "me", "secret")
+	_, otherKH, _ := sshServer(t, "me", "secret")
+
+	d, err := chainDialer(mustHops(t, "ssh://me:secret@"+addr+"?known_hosts="+url.QueryEscape(otherKH)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DialContext(context.Background(), "tcp", target); err == nil {
+		t.Error("dial via an ssh hop with an unknown host key succeeded")
+	}
+
+	d, err = chainDialer(mustHops(t, "ssh://me:secret@"+addr+"?insecure=true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, d, target)
+}
+
+func TestSSHHopStalled(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	// a jump host that accepts connections but never answers the handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	d, err := chainDialer(mustHops(t, "ssh://me:secret@"+l.Addr().String()+"?insecure=true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := d.DialContext(ctx, "tcp", closedAddr(t)); err == nil {
+		t.Error("dial via a stalled ssh hop succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("dial via a stalled ssh hop took %s, want it to end with ctx", elapsed)
+	}
+}
+
+func TestSSHHopDialCancelled(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	target := echoServer(t)
+	addr, kh, conns := sshServer(t, "me", "secret")
+	d, err := chainDialer(mustHops(t, "ssh://me:secret@"+addr+"?known_hosts="+url.QueryEscape(kh)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, d, target)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := d.DialContext(ctx, "tcp", net.JoinHostPort(stallHost, "80")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v dialling a stalled target, want the deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("dial of a stalled target took %s, want it to end with ctx", elapsed)
+	}
+
+	// the ssh connection is still shared
+	roundTrip(t, d, target)
+	if n := atomic.LoadInt32(conns); n != 1 {
+		t.Errorf("got %d ssh connections after a cancelled dial, want 1", n)
+	}
+}
+
+func TestChainedHops(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	target := echoServer(t)
+	proxy := httptest.NewServer(connectProxy("", ""))
+	defer proxy.Close()
+	socks := socks5Server(t, "", "")
+	sshAddr, kh, _ := sshServer(t, "me", "secret")
+
+	// client -> http proxy -> socks5 -> ssh jump host -> target
+	spec := proxy.URL + ",socks5://" + socks + ",ssh://me:secret@" + sshAddr + "?known_hosts=" + url.QueryEscape(kh)
+	d, err := chainDialer(mustHops(t, spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, d, target)
+}
@@ -0,0 +1,249 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// journalTTL is how long the journal remembers a submission
+const journalTTL = 7 * 24 * time.Hour
+
+// journalPruneInterval is how often the journal forgets expired submissions
+const journalPruneInterval = 24 * time.Hour
+
+// journalPruned is the journal file whose modification time is when it was
+// last pruned
+const journalPruned = ".pruned"
+
+// NewIdempotencyKey returns a random key identifying one logical job
+// submission
+func NewIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// WithJournal sets the folder of the local journal that records the job
+// submitted for each idempotency key, for gostint servers that cannot look a
+// job up by its key.  Only submissions with a key given by the caller, or that
+// were retried, are recorded.  Defaults to gostint/journal in the user's cache
+// folder, "" disables the journal.
+func WithJournal(dir string) Option {
+	return func(cl *Client) error {
+		cl.journal = journal{dir: dir}
+		return nil
+	}
+}
+
+func defaultJournal() journal {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return journal{}
+	}
+	return journal{dir: filepath.Join(dir, "gostint", "journal")}
+}
+
+// journal maps idempotency keys to job ids, one file per submission so
+// concurrent clients don't contend
+type journal struct {
+	dir string
+}
+
+func (j journal) file(gostintURL, key string) string {
+	sum := sha256.Sum256([]byte(gostintURL + "\n" + key))
+	return filepath.Join(j.dir, hex.EncodeToString(sum[:]))
+}
+
+// lookup returns the job id recorded for key, or "" if none
+func (j journal) lookup(gostintURL, key string) string {
+	if j.dir == "" {
+		return ""
+	}
+	b, err := ioutil.ReadFile(j.file(gostintURL, key))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// record remembers the job id submitted with key, forgetting expired entries
+func (j journal) record(gostintURL, key, ID string) error {
+	if j.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(j.dir, 0700); err != nil {
+		return err
+	}
+	j.prune()
+	tmp, err := ioutil.TempFile(j.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.WriteString(ID + "\n"); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), j.file(gostintURL, key))
+}
+
+// prune forgets expired entries, unless done within journalPruneInterval
+func (j journal) prune() {
+	marker := filepath.Join(j.dir, journalPruned)
+	if fi, err := os.Stat(marker); err == nil && time.Since(fi.ModTime()) < journalPruneInterval {
+		return
+	}
+	if err := ioutil.WriteFile(marker, nil, 0600); err != nil {
+		return
+	}
+	entries, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if time.Since(e.ModTime()) > journalTTL {
+			os.Remove(filepath.Join(j.dir, e.Name()))
+		}
+	}
+}
+
+// FindJob returns the job submitted with the idempotency key, or nil if there
+// is none.  The local journal is consulted first, then the gostint server if
+// it records keys.
+func (cl *Client) FindJob(ctx context.Context, token string, key string) (*GetResponse, error) {
+	if ID := cl.journal.lookup(cl.url, key); ID != "" {
+		getResp, err := cl.GetJob(ctx, token, ID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if err == nil && getResp.ID == ID {
+			return getResp, nil
+		}
+		cl.debug("Job %s in the journal for idempotency key %s is no longer known to gostint", ID, key)
+	}
+
+	q := url.Values{}
+	q.Set("idempotency_key", key)
+	body, err := cl.do(ctx, "GET", jobPath+"?"+q.Encode(), nil, token)
+	if err != nil {
+		if IsRetryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		cl.debug("Looking up job by idempotency key not supported by server: %s", err)
+		return nil, nil
+	}
+	jobs := []GetResponse{}
+	if err = json.Unmarshal(body, &jobs); err != nil {
+		cl.debug("Looking up job by idempotency key not supported by server: %s", err)
+		return nil, nil
+	}
+	// a server that ignores the key returns jobs that don't match it
+	for i := range jobs {
+		if jobs[i].IdempotencyKey == key {
+			return &jobs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// ambiguous returns true if a failed submission may nonetheless have been
+// accepted by gostint
+func ambiguous(err error) bool {
+	return retryable(err, true) && !retryable(err, false)
+}
+
+// submitOrFind submits a job, and if the outcome is unknown, looks for the
+// job by its idempotency key in case gostint accepted it.  The job is recorded
+// in the journal if the key was given by the caller, explicit, to attach to
+// the job on a re-run, or the submission was retried.
+func (cl *Client) submitOrFind(ctx context.Context, jsonBytes []byte, token string, key string, explicit bool) (string, error) {
+	subResp, retried, err := cl.submitJob(ctx, jsonBytes, token, key)
+	if err == nil {
+		if explicit || retried {
+			if jerr := cl.journal.record(cl.url, key, subResp.ID); jerr != nil {
+				cl.debug("Failed to record job %s in the journal: %s", subResp.ID, jerr)
+			}
+		}
+		return subResp.ID, nil
+	}
+	if ctx.Err() != nil {
+		return "", cl.killSubmitted(ctx, token, key, err)
+	}
+	if !ambiguous(err) {
+		return "", err
+	}
+
+	cl.debug("Job submission outcome unknown, looking for it by idempotency key %s", key)
+	existing, ferr := cl.FindJob(ctx, token, key)
+	if ferr == nil && existing != nil {
+		cl.debug("Job %s was accepted", existing.ID)
+		return existing.ID, nil
+	}
+	return "", fmt.Errorf("%w, the job may have been accepted with idempotency key %s", err, key)
+}
+
+// killSubmitted kills the job a submission that was cancelled or timed out may
+// nonetheless have submitted, looking it up by its idempotency key
+func (cl *Client) killSubmitted(ctx context.Context, token string, key string, err error) error {
+	why := "cancelled"
+	if ctx.Err() == context.DeadlineExceeded {
+		why = "timed out"
+	}
+	cause := fmt.Errorf("job submission %s: %w", why, err)
+
+	// the caller's context is done, so allow the lookup its own time
+	fctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	existing, ferr := cl.FindJob(fctx, token, key)
+	if ferr != nil {
+		return fmt.Errorf("%w, the job may have been accepted with idempotency key %s: %s", cause, key, ferr)
+	}
+	if existing == nil {
+		return cause
+	}
+	cl.debug("Job %s was accepted before the submission was %s", existing.ID, why)
+	return cl.killUnfinished(token, existing.ID, fmt.Errorf("%w, job %s", cause, existing.ID))
+}
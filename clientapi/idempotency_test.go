@@ -0,0 +1,181 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// acceptUnanswered accepts jobs but doesn't answer their submission until
+// the client gives up
+func acceptUnanswered(n int, w http.ResponseWriter, r *http.Request) bool {
+	<-r.Context().Done()
+	return true
+}
+
+func TestSubmitTimedOutKillsJob(t *testing.T) {
+	g := startFakeGostint(t, &fakeGostint{submit: acceptUnanswered})
+	cl, err := NewClient(WithURL(g.URL), WithJournal(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = cl.submitOrFind(ctx, []byte(`{}`), "token", "key-1", true)
+	if err == nil || !strings.Contains(err.Error(), "timed out") || !strings.Contains(err.Error(), "killed") {
+		t.Errorf("got %v, want a timed out and killed error", err)
+	}
+	if killed := g.Killed(); len(killed) != 1 || killed[0] != "job-1" {
+		t.Errorf("killed %v, want [job-1]", killed)
+	}
+}
+
+func TestSubmitCancelledBeforeAccepted(t *testing.T) {
+	g := startFakeGostint(t, &fakeGostint{submit: acceptUnanswered})
+	cl, err := NewClient(WithURL(g.URL), WithJournal(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cl.submitOrFind(ctx, []byte(`{}`), "token", "key-1", true)
+	if err == nil || !strings.Contains(err.Error(), "cancelled") || strings.Contains(err.Error(), "killed") {
+		t.Errorf("got %v, want a cancelled error without a kill", err)
+	}
+	if killed := g.Killed(); len(killed) != 0 {
+		t.Errorf("killed %v, want none", killed)
+	}
+}
+
+func TestSubmitJournal(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		explicit bool
+		status   int // of the first submission, 0 to accept it
+		recorded bool
+	}{
+		{"generated key", false, 0, false},
+		{"given key", true, 0, true},
+		{"generated key retried", false, http.StatusServiceUnavailable, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := startFakeGostint(t, &fakeGostint{submit: func(n int, w http.ResponseWriter, r *http.Request) bool {
+				if n == 1 && tc.status != 0 {
+					w.WriteHeader(tc.status)
+					return true
+				}
+				return false
+			}})
+			p := DefaultRetryPolicy()
+			p.Interval = time.Millisecond
+			cl, err := NewClient(WithURL(g.URL), WithJournal(t.TempDir()), WithRetryPolicy(p))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ID, err := cl.submitOrFind(context.Background(), []byte(`{}`), "token", "key-1", tc.explicit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cl.journal.lookup(g.URL, "key-1"); (got == ID) != tc.recorded {
+				t.Errorf("journal has %q for %s, want recorded %v", got, ID, tc.recorded)
+			}
+		})
+	}
+}
+
+func TestJournalPrunesDaily(t *testing.T) {
+	j := journal{dir: t.TempDir()}
+	old := time.Now().Add(-journalTTL - time.Hour)
+	if err := j.record("https://gostint", "a", "job-1"); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(j.file("https://gostint", "a"), old, old)
+
+	// pruned within the day, so the expired entry is kept until the next
+	if err := j.record("https://gostint", "b", "job-2"); err != nil {
+		t.Fatal(err)
+	}
+	if j.lookup("https://gostint", "a") != "job-1" {
+		t.Error("journal pruned again within a day")
+	}
+
+	os.Chtimes(filepath.Join(j.dir, journalPruned), old, old)
+	if err := j.record("https://gostint", "c", "job-3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := j.lookup("https://gostint", "a"); got != "" {
+		t.Errorf("expired entry still has %q", got)
+	}
+	if j.lookup("https://gostint", "b") != "job-2" || j.lookup("https://gostint", "c") != "job-3" {
+		t.Error("pruning lost entries that haven't expired")
+	}
+}
+
+func TestFindJob(t *testing.T) {
+	g := startFakeGostint(t, &fakeGostint{})
+	cl, err := NewClient(WithURL(g.URL), WithJournal(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gostint doesn't know the key of a job only in the journal
+	journalled := g.addJob("")
+	if err = cl.journal.record(g.URL, "journalled", journalled); err != nil {
+		t.Fatal(err)
+	}
+	keyed := g.addJob("keyed")
+	if err = cl.journal.record(g.URL, "gone", "job-99"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		key  string
+		want string // "" for none
+	}{
+		{"journalled", journalled},
+		{"keyed", keyed},
+		{"gone", ""}, // no longer known to gostint
+		{"unknown", ""},
+	} {
+		got, err := cl.FindJob(context.Background(), "token", tc.key)
+		if err != nil {
+			t.Errorf("%s: %v", tc.key, err)
+			continue
+		}
+		if (got == nil && tc.want != "") || (got != nil && got.ID != tc.want) {
+			t.Errorf("%s: got %+v, want %q", tc.key, got, tc.want)
+		}
+	}
+}
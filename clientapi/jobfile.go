@@ -0,0 +1,323 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var varNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Interpolate expands ${VAR} and ${VAR:-default} in text, taking values from
+// vars, else from the environment.  $${ escapes a literal ${.  Errors are
+// reported against name and the line number in text.
+func Interpolate(name string, text string, vars map[string]string) (string, error) {
+	var out strings.Builder
+	for n, line := range strings.SplitAfter(text, "\n") {
+		v, _, err := expandVars(line, vars)
+		if err != nil {
+			return "", fmt.Errorf("%s:%d: %s", name, n+1, err)
+		}
+		out.WriteString(v)
+	}
+	return out.String(), nil
+}
+
+// expandVars expands the variable references in s, returning true if it had
+// any
+func expandVars(s string, vars map[string]string) (string, bool, error) {
+	var out strings.Builder
+	found := false
+	for {
+		i := strings.Index(s, "$")
+		if i < 0 {
+			out.WriteString(s)
+			return out.String(), found, nil
+		}
+		out.WriteString(s[:i])
+		s = s[i:]
+		switch {
+		case strings.HasPrefix(s, "$${"):
+			out.WriteString("${")
+			s = s[3:]
+		case strings.HasPrefix(s, "${"):
+			end := strings.IndexAny(s, "}\n")
+			if end < 0 || s[end] != '}' {
+				return "", found, fmt.Errorf("unterminated variable reference")
+			}
+			v, err := lookupVar(s[2:end], vars)
+			if err != nil {
+				return "", found, err
+			}
+			out.WriteString(v)
+			found = true
+			s = s[end+1:]
+		default:
+			out.WriteString("$")
+			s = s[1:]
+		}
+	}
+}
+
+// lookupVar returns the value of a VAR or VAR:-default expression
+func lookupVar(expr string, vars map[string]string) (string, error) {
+	name, def, hasDef := expr, "", false
+	if i := strings.Index(expr, ":-"); i >= 0 {
+		name, def, hasDef = expr[:i], expr[i+2:], true
+	}
+	if !varNameRe.MatchString(name) {
+		return "", fmt.Errorf("invalid variable name '%s'", name)
+	}
+	v, ok := vars[name]
+	if !ok {
+		v, ok = os.LookupEnv(name)
+	}
+	switch {
+	case hasDef && v == "":
+		return def, nil
+	case !ok:
+		return "", fmt.Errorf("variable %s is not set, use ${%s:-default} to give a default", name, name)
+	}
+	return v, nil
+}
+
+// isYAML returns true if a job file is YAML, by its extension, else if its
+// content doesn't look like JSON
+func isYAML(name string, text string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return true
+	case ".json":
+		return false
+	}
+	return !strings.HasPrefix(strings.TrimSpace(text), "{")
+}
+
+// JobJSON returns a job given as JSON or YAML as JSON, having interpolated
+// its variables (see expandNode).  name is the job's file name, used to detect
+// YAML by its extension and to report errors.
+func JobJSON(name string, text string, vars map[string]string) (string, error) {
+	doc, p := parseJob(name, text)
+	if p != nil {
+		return "", &ValidationError{Problems: []Problem{*p}}
+	}
+	if err := ProblemsError(expandNode(name, doc, vars)); err != nil {
+		return "", err
+	}
+	return jobNodeJSON(name, doc)
+}
+
+// LoadJob is JobJSON, also validating the job (see ValidateJob) once its
+// variables are interpolated.  It returns a *ValidationError listing every
+// error found, otherwise the job's JSON and any warnings.
+func LoadJob(name string, text string, vars map[string]string, strict bool) (string, []Problem, error) {
+	doc, p := parseJob(name, text)
+	if p != nil {
+		return "", nil, &ValidationError{Problems: []Problem{*p}}
+	}
+	if err := ProblemsError(expandNode(name, doc, vars)); err != nil {
+		return "", nil, err
+	}
+	problems := validateJob(name, doc, strict)
+	if err := ProblemsError(problems); err != nil {
+		return "", nil, err
+	}
+	js, err := jobNodeJSON(name, doc)
+	return js, problems, err
+}
+
+// expandNode interpolates the variables (see Interpolate) of the values of a
+// parsed job, returning a problem for each reference that can't be expanded.
+// Values are expanded within the parsed scalars, so are always data: they
+// can't change the job's structure, and a scalar with a reference is a string
+// whatever its value.  Mapping keys are left as written.
+func expandNode(name string, n *yaml.Node, vars map[string]string) []Problem {
+	problems := []Problem{}
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			problems = append(problems, expandNode(name, c, vars)...)
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			problems = append(problems, expandNode(name, n.Content[i], vars)...)
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(n.Value, "$") {
+			break
+		}
+		v, found, err := expandVars(n.Value, vars)
+		if err != nil {
+			problems = append(problems, Problem{File: name, Line: n.Line, Column: n.Column, Message: err.Error()})
+			break
+		}
+		n.Value = v
+		if found {
+			n.Tag = "!!str"
+		}
+	}
+	return problems
+}
+
+// jobNodeJSON converts a parsed job to JSON.  The job is converted from the
+// same yaml.v3 nodes that ValidateJob checks, so each value keeps the type it
+// was validated as.
+func jobNodeJSON(name string, doc *yaml.Node) (string, error) {
+	v, err := nodeJSON(name, doc)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", name, err)
+	}
+	return string(b), nil
+}
+
+// jsonNumberRe matches a number as written in JSON
+var jsonNumberRe = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// nodeJSON returns the value of a YAML node to marshal as JSON, by the tag
+// yaml.v3 resolved it to.  Numbers are kept as written where JSON allows.
+func nodeJSON(name string, n *yaml.Node) (interface{}, error) {
+	n = resolveAlias(n)
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return nodeJSON(name, n.Content[0])
+	case yaml.SequenceNode:
+		l := make([]interface{}, 0, len(n.Content))
+		for _, item := range n.Content {
+			v, err := nodeJSON(name, item)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		return l, nil
+	case yaml.MappingNode:
+		m := map[string]interface{}{}
+		if err := mappingJSON(name, n, m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	switch n.Tag {
+	case "!!null":
+		return nil, nil
+	case "!!int", "!!float":
+		if jsonNumberRe.MatchString(n.Value) {
+			return json.Number(n.Value), nil
+		}
+		var f float64
+		if err := n.Decode(&f); err != nil {
+			return nil, nodeProblem(name, n, err.Error())
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, nodeProblem(name, n, fmt.Sprintf("%s can't be given in a job, quote it to make it a string", n.Value))
+		}
+		return f, nil
+	case "!!bool":
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return nil, nodeProblem(name, n, err.Error())
+		}
+		return b, nil
+	}
+	// strings, and timestamps, binary and custom tags as written
+	return n.Value, nil
+}
+
+// mappingJSON adds the fields of a mapping node to m, those merged in by <<
+// first so the mapping's own fields override them
+func mappingJSON(name string, n *yaml.Node, m map[string]interface{}) error {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Tag != "!!merge" {
+			continue
+		}
+		v := resolveAlias(n.Content[i+1])
+		merged := []*yaml.Node{v}
+		if v.Kind == yaml.SequenceNode {
+			merged = v.Content
+		}
+		// earlier mappings in a list override later ones
+		for j := len(merged) - 1; j >= 0; j-- {
+			mn := resolveAlias(merged[j])
+			if mn.Kind != yaml.MappingNode {
+				return nodeProblem(name, mn, "<< must merge a mapping or a list of mappings")
+			}
+			if err := mappingJSON(name, mn, m); err != nil {
+				return err
+			}
+		}
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Tag == "!!merge" {
+			continue
+		}
+		v, err := nodeJSON(name, n.Content[i+1])
+		if err != nil {
+			return err
+		}
+		m[resolveAlias(n.Content[i]).Value] = v
+	}
+	return nil
+}
+
+// nodeProblem returns a *ValidationError of a problem with a node
+func nodeProblem(name string, n *yaml.Node, msg string) error {
+	return &ValidationError{Problems: []Problem{{File: name, Line: n.Line, Column: n.Column, Message: msg}}}
+}
+
+// unmarshalYAML decodes a file of jobs given as JSON or YAML, e.g. a batch
+// manifest, into v by its json field tags, reading values just as a job file
+// is read
+func unmarshalYAML(name string, b []byte, v interface{}) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("%s", syntaxProblem(name, err))
+	}
+	js, err := jobNodeJSON(name, &doc)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal([]byte(js), v); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandJobYAMLValues(t *testing.T) {
+	for _, tc := range []struct {
+		name, job, value string
+		want             map[string]interface{}
+	}{
+		{"plain", "qname: ${V}\n", "play", map[string]interface{}{"qname": "play"}},
+		{"plain part", "qname: a-${V}-b\n", "x", map[string]interface{}{"qname": "a-x-b"}},
+		{"plain field", "qname: ${V}\n", "x\ncontainer_image: evil", map[string]interface{}{"qname": "x\ncontainer_image: evil"}},
+		{"plain mapping", "qname: ${V}\n", "a: b", map[string]interface{}{"qname": "a: b"}},
+		{"plain comment", "qname: ${V} # queue\n", "a #b", map[string]interface{}{"qname": "a #b"}},
+		{"plain indicator", "qname: ${V}\n", "[x]", map[string]interface{}{"qname": "[x]"}},
+		{"plain empty", "qname: ${V}\n", "", map[string]interface{}{"qname": ""}},
+		{"sequence", "run:\n  - ${V}\n", "-i\n- evil", map[string]interface{}{"run": []interface{}{"-i\n- evil"}}},
+		{"flow", "run: [\"${V}\", b]\n", "a, c", map[string]interface{}{"run": []interface{}{"a, c", "b"}}},
+		{"double", "qname: \"a ${V}\"\n", "x\", \"y\n", map[string]interface{}{"qname": "a x\", \"y\n"}},
+		{"single", "qname: 'a ${V}'\n", "it's", map[string]interface{}{"qname": "a it's"}},
+		{"flow double", "run: [\"${V}\", b]\n", "a\", \"c", map[string]interface{}{"run": []interface{}{"a\", \"c", "b"}}},
+		{"block", "run:\n  - |\n    echo ${V}\nqname: q\n", "a\nqname: evil",
+			map[string]interface{}{"run": []interface{}{"echo a\nqname: evil\n"}, "qname": "q"}},
+		{"apostrophe", "qname: don't ${V}\n", "x: y", map[string]interface{}{"qname": "don't x: y"}},
+		{"single line break", "qname: '${V}'\n", "a\nb", map[string]interface{}{"qname": "a\nb"}},
+		{"number", "future: ${V}\n", "1.10", map[string]interface{}{"future": "1.10"}},
+		{"bool", "cont_on_warnings: ${V}\n", "true", map[string]interface{}{"cont_on_warnings": "true"}},
+		{"null", "qname: ${V}\n", "null", map[string]interface{}{"qname": "null"}},
+		{"yaml 1.1 bool", "run:\n  - echo\n  - ${V}\n", "no", map[string]interface{}{"run": []interface{}{"echo", "no"}}},
+		{"key", "${V}: q\n", "qname", map[string]interface{}{"${V}": "q"}},
+		{"escape", "qname: $${V}\n", "x", map[string]interface{}{"qname": "${V}"}},
+		{"comment", "# ${V}\nqname: q\n", "x\nqname: evil", map[string]interface{}{"qname": "q"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			js, err := JobJSON("job.yaml", tc.job, map[string]string{"V": tc.value})
+			if tc.want == nil {
+				if err == nil {
+					t.Errorf("got %s, want an error", js)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]interface{}{}
+			if err = json.Unmarshal([]byte(js), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExpandJobLocatesProblems(t *testing.T) {
+	_, err := JobJSON("job.yaml", "qname: q\nrun:\n  - ${UNSET_VAR}\n  - |\n    a ${1A}\n", nil)
+	want := `invalid job:
+  job.yaml:3:5: variable UNSET_VAR is not set, use ${UNSET_VAR:-default} to give a default
+  job.yaml:4:5: invalid variable name '1A'`
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("GOSTINT_TEST_ENV", "from-env")
+	t.Setenv("GOSTINT_TEST_EMPTY", "")
+	vars := map[string]string{"A": "a", "EMPTY": "", "GOSTINT_TEST_ENV": "from-set"}
+	for _, tc := range []struct {
+		text, want, err string
+	}{
+		{text: "x ${A} y", want: "x a y"},
+		{text: "${A}${A}", want: "aa"},
+		{text: "${UNSET_VAR:-def}", want: "def"},
+		{text: "${A:-def}", want: "a"},
+		{text: "${EMPTY:-def}", want: "def"},
+		{text: "${EMPTY}", want: ""},
+		{text: "${UNSET_VAR:-}", want: ""},
+		{text: "${GOSTINT_TEST_ENV}", want: "from-set"},
+		{text: "${GOSTINT_TEST_EMPTY:-def}", want: "def"},
+		{text: "$${A} $${UNSET_VAR}", want: "${A} ${UNSET_VAR}"},
+		{text: "$A $ $$ {A}", want: "$A $ $$ {A}"},
+		{text: "one\n${UNSET_VAR}", err: "job.yaml:2: variable UNSET_VAR is not set"},
+		{text: "one\ntwo\n${A", err: "job.yaml:3: unterminated variable reference"},
+		{text: "${1A}", err: "job.yaml:1: invalid variable name '1A'"},
+	} {
+		got, err := Interpolate("job.yaml", tc.text, vars)
+		switch {
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%q: got %q, %v, want error %q", tc.text, got, err, tc.err)
+		case tc.err == "" && err != nil:
+			t.Errorf("%q: got error %s, want %q", tc.text, err, tc.want)
+		case tc.err == "" && got != tc.want:
+			t.Errorf("%q: got %q, want %q", tc.text, got, tc.want)
+		}
+	}
+
+	if got, _ := Interpolate("job.yaml", "${GOSTINT_TEST_ENV}", nil); got != "from-env" {
+		t.Errorf("got %q, want the value from the environment", got)
+	}
+}
+
+func TestExpandJobJSONQuoting(t *testing.T) {
+	js, err := JobJSON("job.json", `{"qname": "${V}", "run": ["${V}"]}`, map[string]string{"V": `a", "container_image": "evil`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := JobSpec{}
+	if err = json.Unmarshal([]byte(js), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if want := `a", "container_image": "evil`; spec.QName != want || spec.ContainerImage != "" || len(spec.Run) != 1 || spec.Run[0] != want {
+		t.Errorf("got %+v, want the value kept within qname and run", spec)
+	}
+}
+
+func TestJobJSONReadsAsValidated(t *testing.T) {
+	for _, tc := range []struct {
+		name, job, want string
+	}{
+		{"yaml 1.1 bools are strings", "qname: on\nrun: [echo, no, yes, off]\n", `{"qname":"on","run":["echo","no","yes","off"]}`},
+		{"bools", "cont_on_warnings: true\n", `{"cont_on_warnings":true}`},
+		{"numbers as written", "a: 1.10\nb: 12345678901234567890\nc: 0x1F\nd: ~\n", `{"a":1.10,"b":12345678901234567890,"c":31,"d":null}`},
+		{"timestamp", "a: 2001-12-14\n", `{"a":"2001-12-14"}`},
+		{"merge", "base: &base {qname: a, run: [x]}\njob:\n  <<: *base\n  qname: b\n", `{"base":{"qname":"a","run":["x"]},"job":{"qname":"b","run":["x"]}}`},
+		{"json", `{"qname": "q", "retries": 3, "extra": {"n": 1.5e3}}`, `{"extra":{"n":1.5e3},"qname":"q","retries":3}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			js, err := JobJSON("job.yaml", tc.job, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if js != tc.want {
+				t.Errorf("got %s, want %s", js, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadJobRejectsAsConverted(t *testing.T) {
+	for _, tc := range []struct {
+		job, err string
+	}{
+		{"qname: true\n", "invalid job: job.yaml:1:8: qname must be a string, quote true to make it one"},
+		{"run: [echo, false]\n", "invalid job: job.yaml:1:13: run[1] must be a string, quote false to make it one"},
+		{"future: .inf\n", "invalid job: job.yaml:1:9: .inf can't be given in a job, quote it to make it a string"},
+	} {
+		_, _, err := LoadJob("job.yaml", tc.job, nil, false)
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%q: got %v, want %s", tc.job, err, tc.err)
+		}
+	}
+}
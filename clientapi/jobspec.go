@@ -0,0 +1,268 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// JobSpec is a job for gostint to run in a container
+type JobSpec struct {
+	QName           string   `json:"qname"`
+	ContainerImage  string   `json:"container_image"`
+	ImagePullPolicy string   `json:"image_pull_policy"`
+	Content         string   `json:"content"` // "targz,<base64>", see EncodeContent
+	EntryPoint      []string `json:"entrypoint"`
+	Run             []string `json:"run"`
+	WorkingDir      string   `json:"working_directory"`
+	EnvVars         []string `json:"env_vars"`    // NAME=value
+	SecretRefs      []string `json:"secret_refs"` // name@vault/path.field
+	SecretFileType  string   `json:"secret_file_type"`
+	ContOnWarnings  bool     `json:"cont_on_warnings"`
+
+	// Extra holds fields of the job JSON unknown to this client, e.g. options
+	// of newer gostint servers, which are passed through to gostint unchanged
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// knownJobFields are the JobSpec json field names, lower cased as json
+// matches them case insensitively
+var knownJobFields = func() map[string]bool {
+	known := map[string]bool{}
+	t := reflect.TypeOf(JobSpec{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+	// a job file's JSON Schema reference, for editors, isn't sent to gostint
+	known["$schema"] = true
+	return known
+}()
+
+// jobFieldEnums are the values allowed in the enumerated JobSpec fields, which
+// may also be left empty
+var jobFieldEnums = map[string][]string{
+	"image_pull_policy": {"IfNotPresent", "Always"},
+	"secret_file_type":  {"yaml", "json"},
+}
+
+// jobItemFormat is the format of the entries of a JobSpec list field, as a
+// regular expression valid in both Go and JSON Schema
+type jobItemFormat struct {
+	re   *regexp.Regexp
+	desc string
+}
+
+// jobItemFormats are the formats of the JobSpec list fields that have one
+var jobItemFormats = map[string]jobItemFormat{
+	"env_vars":    {regexp.MustCompile(`^[^=\s]+=`), "NAME=value"},
+	"secret_refs": {regexp.MustCompile(`^[^@]+@.+\.[^.]+$`), "name@path.field"},
+}
+
+// UnknownJobFields returns the sorted names of the fields of a job spec that
+// this client doesn't know
+func UnknownJobFields(spec map[string]interface{}) []string {
+	unknown := []string{}
+	for k := range spec {
+		if !knownJobFields[strings.ToLower(k)] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// UnknownFields returns the sorted names of the fields in Extra
+func (s *JobSpec) UnknownFields() []string {
+	unknown := []string{}
+	for k := range s.Extra {
+		unknown = append(unknown, k)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// UnmarshalJSON decodes a job, keeping unknown fields in Extra
+func (s *JobSpec) UnmarshalJSON(b []byte) error {
+	type plain JobSpec
+	if err := json.Unmarshal(b, (*plain)(s)); err != nil {
+		return err
+	}
+	all := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+	s.Extra = nil
+	for k, v := range all {
+		if knownJobFields[strings.ToLower(k)] {
+			continue
+		}
+		if s.Extra == nil {
+			s.Extra = map[string]json.RawMessage{}
+		}
+		s.Extra[k] = v
+	}
+	return nil
+}
+
+// MarshalJSON encodes a job, including the unknown fields in Extra, other
+// than any named as a known field
+func (s JobSpec) MarshalJSON() ([]byte, error) {
+	type plain JobSpec
+	b, err := json.Marshal(plain(s))
+	if err != nil || len(s.Extra) == 0 {
+		return b, err
+	}
+	all := map[string]json.RawMessage{}
+	if err = json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	for k, v := range s.Extra {
+		// gostint matches field names case insensitively, so an extra field
+		// differing from a known one only in case could override it
+		if !knownJobFields[strings.ToLower(k)] {
+			all[k] = v
+		}
+	}
+	return json.Marshal(all)
+}
+
+// JobOption sets part of a JobSpec in NewJobSpec
+type JobOption func(*JobSpec)
+
+// NewJobSpec returns a JobSpec built from the options passed, e.g.
+//
+//	NewJobSpec(JobQName("play"), JobImage("alpine"), JobRun("cat", "/etc/os-release"))
+func NewJobSpec(opts ...JobOption) *JobSpec {
+	s := &JobSpec{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// JobQName sets the queue to submit the job to
+func JobQName(qname string) JobOption {
+	return func(s *JobSpec) { s.QName = qname }
+}
+
+// JobImage sets the docker image to run the job within
+func JobImage(image string) JobOption {
+	return func(s *JobSpec) { s.ContainerImage = image }
+}
+
+// JobImagePullPolicy sets the image pull policy, IfNotPresent or Always
+func JobImagePullPolicy(policy string) JobOption {
+	return func(s *JobSpec) { s.ImagePullPolicy = policy }
+}
+
+// JobContent sets the encoded content to inject into the container, see
+// EncodeContent
+func JobContent(content string) JobOption {
+	return func(s *JobSpec) { s.Content = content }
+}
+
+// JobEntryPoint sets the container's entrypoint
+func JobEntryPoint(parts ...string) JobOption {
+	return func(s *JobSpec) { s.EntryPoint = parts }
+}
+
+// JobRun sets the command to run in the container
+func JobRun(parts ...string) JobOption {
+	return func(s *JobSpec) { s.Run = parts }
+}
+
+// JobWorkingDir sets the working directory within the container
+func JobWorkingDir(dir string) JobOption {
+	return func(s *JobSpec) { s.WorkingDir = dir }
+}
+
+// JobEnv adds an environment variable for the job's container
+func JobEnv(name, value string) JobOption {
+	return func(s *JobSpec) { s.EnvVars = append(s.EnvVars, name+"="+value) }
+}
+
+// JobSecretRef adds a vault secret to inject into the job's container as name,
+// taken from field of the secret at path
+func JobSecretRef(name, path, field string) JobOption {
+	return func(s *JobSpec) { s.SecretRefs = append(s.SecretRefs, fmt.Sprintf("%s@%s.%s", name, path, field)) }
+}
+
+// JobSecretFileType sets the injected secret file type, yaml or json
+func JobSecretFileType(fileType string) JobOption {
+	return func(s *JobSpec) { s.SecretFileType = fileType }
+}
+
+// JobContOnWarnings sets whether to run the job even if vault reported
+// warnings looking up its secret refs
+func JobContOnWarnings(cont bool) JobOption {
+	return func(s *JobSpec) { s.ContOnWarnings = cont }
+}
+
+// Validate checks the spec is complete and well formed, returning an error
+// describing every problem found
+func (s *JobSpec) Validate() error {
+	probs := []string{}
+	if s.ContainerImage == "" {
+		probs = append(probs, "container_image is required")
+	}
+	for _, f := range []struct{ field, value string }{
+		{"image_pull_policy", s.ImagePullPolicy},
+		{"secret_file_type", s.SecretFileType},
+	} {
+		if p := checkValue(f.field, jobSchema.Properties[f.field], f.value); p != "" {
+			probs = append(probs, p)
+		}
+	}
+	if s.Content != "" && !strings.HasPrefix(s.Content, "targz,") {
+		probs = append(probs, "content must be encoded as 'targz,<base64>', see EncodeContent")
+	}
+	for _, f := range []struct {
+		field string
+		items []string
+	}{
+		{"env_vars", s.EnvVars},
+		{"secret_refs", s.SecretRefs},
+	} {
+		for i, item := range f.items {
+			if p := checkValue(fmt.Sprintf("%s[%d]", f.field, i), jobSchema.Properties[f.field].Items, item); p != "" {
+				probs = append(probs, p)
+			}
+		}
+	}
+	if len(probs) > 0 {
+		return fmt.Errorf("invalid job: %s", strings.Join(probs, "; "))
+	}
+	return nil
+}
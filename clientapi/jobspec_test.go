@@ -0,0 +1,125 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// specRoundTrip decodes a job and encodes it again, returning it decoded and
+// its encoding as a generic map
+func specRoundTrip(t *testing.T, js string) (*JobSpec, map[string]interface{}) {
+	t.Helper()
+	spec := &JobSpec{}
+	if err := json.Unmarshal([]byte(js), spec); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]interface{}{}
+	if err = json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	return spec, out
+}
+
+func TestJobSpecKeepsUnknownFields(t *testing.T) {
+	spec, out := specRoundTrip(t, `{"qname": "play", "run": ["a"], "future": {"x": [1, 2.5]}, "Later": true, "$schema": "job.schema.json"}`)
+	if want := []string{"Later", "future"}; !reflect.DeepEqual(spec.UnknownFields(), want) {
+		t.Errorf("got unknown fields %q, want %q", spec.UnknownFields(), want)
+	}
+	if out["qname"] != "play" || !reflect.DeepEqual(out["run"], []interface{}{"a"}) {
+		t.Errorf("known fields changed: %v", out)
+	}
+	if !reflect.DeepEqual(out["future"], map[string]interface{}{"x": []interface{}{1.0, 2.5}}) || out["Later"] != true {
+		t.Errorf("unknown fields changed: %v", out)
+	}
+	if _, ok := out["$schema"]; ok {
+		t.Errorf("$schema was passed on: %v", out)
+	}
+
+	// decoding again replaces the unknown fields
+	if err := json.Unmarshal([]byte(`{"qname": "q"}`), spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Extra != nil {
+		t.Errorf("got unknown fields %v after decoding a job without any", spec.Extra)
+	}
+}
+
+func TestJobSpecKnownFieldsInOtherCase(t *testing.T) {
+	spec, out := specRoundTrip(t, `{"QName": "play", "Container_Image": "alpine"}`)
+	if spec.QName != "play" || spec.ContainerImage != "alpine" || len(spec.Extra) != 0 {
+		t.Errorf("got %+v, want the fields known", spec)
+	}
+	if out["qname"] != "play" || out["container_image"] != "alpine" {
+		t.Errorf("got %v", out)
+	}
+	for _, k := range []string{"QName", "Container_Image"} {
+		if _, ok := out[k]; ok {
+			t.Errorf("%s passed on as given: %v", k, out)
+		}
+	}
+}
+
+func TestJobSpecExtraCannotShadowKnownFields(t *testing.T) {
+	spec := JobSpec{QName: "play", Extra: map[string]json.RawMessage{
+		"qname":   json.RawMessage(`"evil"`),
+		"QNAME":   json.RawMessage(`"evil"`),
+		"$schema": json.RawMessage(`"x"`),
+		"future":  json.RawMessage(`1`),
+	}}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]interface{}{}
+	if err = json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["qname"] != "play" || out["future"] != 1.0 {
+		t.Errorf("got %v", out)
+	}
+	for _, k := range []string{"QNAME", "$schema"} {
+		if _, ok := out[k]; ok {
+			t.Errorf("extra %s passed on: %v", k, out)
+		}
+	}
+
+	// as gostint would decode it
+	got := JobSpec{}
+	if err = json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.QName != "play" {
+		t.Errorf("got qname %q, want play", got.QName)
+	}
+}
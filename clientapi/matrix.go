@@ -0,0 +1,297 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MatrixJob is one combination of a job spec's matrix
+type MatrixJob struct {
+	Name   string                 // describes the combination, e.g. "container_image=alpine"
+	Values map[string]interface{} // the axis values of the combination
+	Spec   map[string]interface{} // the job spec for the combination
+}
+
+// HasMatrix returns true if the job JSON has a matrix section
+func HasMatrix(jobJSON string) bool {
+	spec := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(jobJSON), &spec); err != nil {
+		return false
+	}
+	_, ok := spec["matrix"]
+	return ok
+}
+
+// ExpandMatrix expands the matrix section of a job spec into a job spec per
+// combination of its axes:
+//
+//	"matrix": {
+//	  "container_image": ["alpine", "debian"],
+//	  "env_vars": [["PY=2"], ["PY=3"]],
+//	  "exclude": [{"container_image": "debian", "env_vars": ["PY=2"]}],
+//	  "include": [{"container_image": "centos", "env_vars": ["PY=3"]}]
+//	}
+//
+// Each axis is a job field and the list of values it takes, replacing the
+// field's value in the spec, except for env_vars whose values are appended to
+// the spec's env_vars.  Combinations matching every field of an exclude entry
+// are dropped, then each include entry is added as an extra combination.  A
+// spec without a matrix is returned as the only job.
+func ExpandMatrix(spec map[string]interface{}) ([]MatrixJob, error) {
+	raw, ok := spec["matrix"]
+	if !ok {
+		return []MatrixJob{{Spec: spec}}, nil
+	}
+	matrix, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("matrix must be an object of axes")
+	}
+
+	base := map[string]interface{}{}
+	for k, v := range spec {
+		if k != "matrix" {
+			base[k] = v
+		}
+	}
+
+	axes := []string{}
+	for k := range matrix {
+		if k != "include" && k != "exclude" {
+			axes = append(axes, k)
+		}
+	}
+	sort.Strings(axes)
+
+	// cartesian product of the axes
+	combos := []map[string]interface{}{{}}
+	for _, axis := range axes {
+		values, ok := matrix[axis].([]interface{})
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("matrix axis %s must be a non-empty list of values", axis)
+		}
+		next := []map[string]interface{}{}
+		for _, combo := range combos {
+			for _, v := range values {
+				c := map[string]interface{}{}
+				for k, cv := range combo {
+					c[k] = cv
+				}
+				c[axis] = v
+				next = append(next, c)
+			}
+		}
+		combos = next
+	}
+	if len(axes) == 0 {
+		combos = nil
+	}
+
+	excludes, err := matrixEntries(matrix, "exclude")
+	if err != nil {
+		return nil, err
+	}
+	includes, err := matrixEntries(matrix, "include")
+	if err != nil {
+		return nil, err
+	}
+
+	kept := []map[string]interface{}{}
+	for _, combo := range combos {
+		excluded := false
+		for _, ex := range excludes {
+			if matrixMatch(combo, ex) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			kept = append(kept, combo)
+		}
+	}
+	kept = append(kept, includes...)
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("matrix has no combinations")
+	}
+
+	jobs := []MatrixJob{}
+	names := map[string]bool{}
+	for _, combo := range kept {
+		s := map[string]interface{}{}
+		for k, v := range base {
+			s[k] = v
+		}
+		for k, v := range combo {
+			if k == "env_vars" {
+				s[k] = appendEnvVars(s[k], v)
+			} else {
+				s[k] = v
+			}
+		}
+		name := matrixName(combo)
+		if names[name] {
+			continue
+		}
+		names[name] = true
+		jobs = append(jobs, MatrixJob{Name: name, Values: combo, Spec: s})
+	}
+	return jobs, nil
+}
+
+func matrixEntries(matrix map[string]interface{}, key string) ([]map[string]interface{}, error) {
+	raw, ok := matrix[key]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("matrix %s must be a list of objects", key)
+	}
+	entries := []map[string]interface{}{}
+	for _, e := range list {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("matrix %s must be a list of objects", key)
+		}
+		entries = append(entries, m)
+	}
+	return entries, nil
+}
+
+// matrixMatch returns true if the combination has every value in the entry
+func matrixMatch(combo, entry map[string]interface{}) bool {
+	for k, v := range entry {
+		if !reflect.DeepEqual(combo[k], v) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendEnvVars adds an env_vars axis value, a string or list of strings, to
+// the spec's env_vars
+func appendEnvVars(existing interface{}, v interface{}) interface{} {
+	out := []interface{}{}
+	if l, ok := existing.([]interface{}); ok {
+		out = append(out, l...)
+	}
+	if l, ok := v.([]interface{}); ok {
+		return append(out, l...)
+	}
+	return append(out, v)
+}
+
+// matrixName describes a combination, e.g. "container_image=alpine, env_vars=[PY=3]"
+func matrixName(combo map[string]interface{}) string {
+	keys := []string{}
+	for k := range combo {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		v := combo[k]
+		if l, ok := v.([]interface{}); ok {
+			strs := []string{}
+			for _, e := range l {
+				strs = append(strs, fmt.Sprint(e))
+			}
+			v = "[" + strings.Join(strs, " ") + "]"
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", k, v))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// MatrixJobs expands the matrix in the request's job JSON into a batch of
+// requests, one per combination.  The request's individual job fields apply
+// to the job before it is expanded, so the matrix axes override them.
+func MatrixJobs(c APIRequest) ([]BatchJob, error) {
+	if c.JobJSON == nil {
+		return nil, fmt.Errorf("the request has no job JSON to expand")
+	}
+	spec := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(*c.JobJSON), &spec); err != nil {
+		return nil, err
+	}
+	matrix, hasMatrix := spec["matrix"]
+	delete(spec, "matrix")
+	js, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	jsStr := string(js)
+	c.JobJSON = &jsStr
+	job, err := buildJob(c)
+	if err != nil {
+		return nil, err
+	}
+	if js, err = json.Marshal(job); err != nil {
+		return nil, err
+	}
+	spec = map[string]interface{}{}
+	if err = json.Unmarshal(js, &spec); err != nil {
+		return nil, err
+	}
+	if hasMatrix {
+		spec["matrix"] = matrix
+	}
+
+	mjobs, err := ExpandMatrix(spec)
+	if err != nil {
+		return nil, err
+	}
+	base := withoutJobFields(c)
+	jobs := []BatchJob{}
+	for _, mj := range mjobs {
+		req, err := specRequest(mj.Spec, base)
+		if err != nil {
+			return nil, err
+		}
+		if c.IdempotencyKey != nil && *c.IdempotencyKey != "" {
+			// each combination is a submission of its own
+			key := *c.IdempotencyKey + "/" + mj.Name
+			req.IdempotencyKey = &key
+		}
+		jobs = append(jobs, BatchJob{Name: mj.Name, Request: req})
+	}
+	return jobs, nil
+}
+
+// withoutJobFields returns the request with its individual job fields and
+// defaults unset, leaving the job to its JobJSON
+func withoutJobFields(c APIRequest) APIRequest {
+	c.QName, c.ContainerImage, c.ImagePullPolicy, c.Content = nil, nil, nil, nil
+	c.EntryPoint, c.Run, c.WorkingDir, c.EnvVars = nil, nil, nil, nil
+	c.SecretRefs, c.SecretFileType, c.ContOnWarnings = nil, nil, nil
+	c.DefaultQName, c.DefaultImage = nil, nil
+	return c
+}
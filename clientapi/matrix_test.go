@@ -0,0 +1,165 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	type job struct {
+		name string
+		spec string
+	}
+	for _, tc := range []struct {
+		name string
+		spec string
+		want []job // nil for an error
+	}{
+		{"no matrix", `{"qname": "q"}`, []job{{"", `{"qname": "q"}`}}},
+		{"product",
+			`{"qname": "q", "matrix": {"container_image": ["alpine", "debian"], "run": [["a"], ["b"]]}}`,
+			[]job{
+				{"container_image=alpine, run=[a]", `{"qname": "q", "container_image": "alpine", "run": ["a"]}`},
+				{"container_image=alpine, run=[b]", `{"qname": "q", "container_image": "alpine", "run": ["b"]}`},
+				{"container_image=debian, run=[a]", `{"qname": "q", "container_image": "debian", "run": ["a"]}`},
+				{"container_image=debian, run=[b]", `{"qname": "q", "container_image": "debian", "run": ["b"]}`},
+			}},
+		{"axis replaces field",
+			`{"qname": "q", "matrix": {"qname": ["a"]}}`,
+			[]job{{"qname=a", `{"qname": "a"}`}}},
+		{"exclude",
+			`{"matrix": {"container_image": ["alpine", "debian"], "qname": ["a", "b"],
+				"exclude": [{"container_image": "debian", "qname": "a"}, {"qname": "c"}]}}`,
+			[]job{
+				{"container_image=alpine, qname=a", `{"container_image": "alpine", "qname": "a"}`},
+				{"container_image=alpine, qname=b", `{"container_image": "alpine", "qname": "b"}`},
+				{"container_image=debian, qname=b", `{"container_image": "debian", "qname": "b"}`},
+			}},
+		{"exclude partial match",
+			`{"matrix": {"container_image": ["alpine", "debian"], "qname": ["a", "b"], "exclude": [{"qname": "a"}]}}`,
+			[]job{
+				{"container_image=alpine, qname=b", `{"container_image": "alpine", "qname": "b"}`},
+				{"container_image=debian, qname=b", `{"container_image": "debian", "qname": "b"}`},
+			}},
+		{"include",
+			`{"qname": "q", "matrix": {"container_image": ["alpine"], "include": [{"container_image": "centos", "qname": "c"}]}}`,
+			[]job{
+				{"container_image=alpine", `{"qname": "q", "container_image": "alpine"}`},
+				{"container_image=centos, qname=c", `{"qname": "c", "container_image": "centos"}`},
+			}},
+		{"include only",
+			`{"matrix": {"include": [{"container_image": "centos"}]}}`,
+			[]job{{"container_image=centos", `{"container_image": "centos"}`}}},
+		{"env_vars appended",
+			`{"env_vars": ["A=1"], "matrix": {"env_vars": [["PY=2", "X=1"], "PY=3"]}}`,
+			[]job{
+				{"env_vars=[PY=2 X=1]", `{"env_vars": ["A=1", "PY=2", "X=1"]}`},
+				{"env_vars=PY=3", `{"env_vars": ["A=1", "PY=3"]}`},
+			}},
+		{"duplicate dropped",
+			`{"matrix": {"qname": ["a", "b"], "include": [{"qname": "a"}]}}`,
+			[]job{{"qname=a", `{"qname": "a"}`}, {"qname=b", `{"qname": "b"}`}}},
+		{"not an object", `{"matrix": ["a"]}`, nil},
+		{"empty axis", `{"matrix": {"qname": []}}`, nil},
+		{"axis not a list", `{"matrix": {"qname": "a"}}`, nil},
+		{"exclude not a list", `{"matrix": {"qname": ["a"], "exclude": {"qname": "a"}}}`, nil},
+		{"include not objects", `{"matrix": {"qname": ["a"], "include": ["b"]}}`, nil},
+		{"everything excluded", `{"matrix": {"qname": ["a"], "exclude": [{"qname": "a"}]}}`, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(tc.spec), &spec); err != nil {
+				t.Fatal(err)
+			}
+			jobs, err := ExpandMatrix(spec)
+			if tc.want == nil {
+				if err == nil {
+					t.Errorf("got %d jobs, want an error", len(jobs))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(jobs) != len(tc.want) {
+				t.Fatalf("got %d jobs, want %d: %v", len(jobs), len(tc.want), jobs)
+			}
+			for i, w := range tc.want {
+				want := map[string]interface{}{}
+				if err := json.Unmarshal([]byte(w.spec), &want); err != nil {
+					t.Fatal(err)
+				}
+				if jobs[i].Name != w.name {
+					t.Errorf("job %d is named %q, want %q", i, jobs[i].Name, w.name)
+				}
+				if !reflect.DeepEqual(jobs[i].Spec, want) {
+					t.Errorf("job %d spec is %v, want %v", i, jobs[i].Spec, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatrixJobsAxesOverrideFlags(t *testing.T) {
+	str := func(s string) *string { return &s }
+	c := APIRequest{
+		JobJSON:        str(`{"qname": "json", "matrix": {"container_image": ["alpine", "debian"], "env_vars": ["PY=3"]}}`),
+		QName:          str("flag"),
+		ContainerImage: str("centos"),
+		EnvVars:        str(`["A=1"]`),
+		DefaultImage:   str("busybox"),
+		IdempotencyKey: str("key"),
+	}
+	jobs, err := MatrixJobs(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ name, image, key string }{
+		{"container_image=alpine, env_vars=PY=3", "alpine", "key/container_image=alpine, env_vars=PY=3"},
+		{"container_image=debian, env_vars=PY=3", "debian", "key/container_image=debian, env_vars=PY=3"},
+	}
+	if len(jobs) != len(want) {
+		t.Fatalf("got %d jobs, want %d", len(jobs), len(want))
+	}
+	for i, w := range want {
+		spec, err := jobs[i].Request.JobSpec()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jobs[i].Name != w.name || spec.ContainerImage != w.image || *jobs[i].Request.IdempotencyKey != w.key {
+			t.Errorf("got %s with image %s and key %s, want %s with %s and %s",
+				jobs[i].Name, spec.ContainerImage, *jobs[i].Request.IdempotencyKey, w.name, w.image, w.key)
+		}
+		// flags not overridden by an axis still apply, env_vars appended to
+		if spec.QName != "flag" || !reflect.DeepEqual(spec.EnvVars, []string{"A=1", "PY=3"}) {
+			t.Errorf("%s: got qname %s, env_vars %q", jobs[i].Name, spec.QName, spec.EnvVars)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Step conditions, when a step runs relative to the steps it needs
+const (
+	WhenOnSuccess = "on_success" // all needed steps succeeded (default)
+	WhenOnFailure = "on_failure" // any needed step failed
+	WhenAlways    = "always"     // all needed steps finished, whatever their result
+)
+
+// PipelineStep is one job of a pipeline, run once the steps it needs have
+// finished and its when condition is met
+type PipelineStep struct {
+	Name  string                 `json:"name"`
+	Needs []string               `json:"needs"`
+	When  string                 `json:"when"`
+	Job   map[string]interface{} `json:"job"`
+}
+
+// Pipeline is a set of steps with dependencies, in YAML or JSON:
+//
+//	steps:
+//	  - name: build
+//	    job: {...}
+//	  - name: deploy
+//	    needs: [build]
+//	    job:
+//	      run: ["-e", "version={{ trim .Steps.build.Output }}", "site.yml"]
+//	  - name: cleanup
+//	    needs: [deploy]
+//	    when: always
+//	    job: {...}
+//
+// String values in a step's job are Go templates, expanded when the step runs,
+// with .Steps holding the ID, Status, ReturnCode and Output of the steps that
+// have finished, e.g. {{ (index .Steps "my-step").Output }}.
+type Pipeline struct {
+	Steps []PipelineStep `json:"steps"`
+
+	base APIRequest
+}
+
+// StepOutput is the result of a finished step available to later steps'
+// templates
+type StepOutput struct {
+	ID         string
+	Status     string
+	ReturnCode int
+	Output     string
+}
+
+// StepResult is the outcome of a pipeline step
+type StepResult struct {
+	BatchResult
+	Skipped bool
+	Reason  string // why the step was skipped
+}
+
+// LoadPipeline reads and checks a pipeline file, each step's job being built
+// over base.  Content folders are relative to the pipeline file.
+func LoadPipeline(file string, base APIRequest) (*Pipeline, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p := Pipeline{base: base}
+	if err = unmarshalYAML(file, b, &p); err != nil {
+		return nil, err
+	}
+	if err = p.check(); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	dir := filepath.Dir(file)
+	contents := map[string]string{}
+	for _, s := range p.Steps {
+		if err = encodeSpecContent(s.Job, dir, contents); err != nil {
+			return nil, fmt.Errorf("%s: step %s: %s", file, s.Name, err)
+		}
+	}
+	return &p, nil
+}
+
+// check the steps are uniquely named, conditions are valid and needs form a
+// directed acyclic graph
+func (p *Pipeline) check() error {
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("no steps in pipeline")
+	}
+	steps := map[string]*PipelineStep{}
+	for i := range p.Steps {
+		s := &p.Steps[i]
+		if s.Name == "" {
+			return fmt.Errorf("step %d has no name", i+1)
+		}
+		if steps[s.Name] != nil {
+			return fmt.Errorf("duplicate step name '%s'", s.Name)
+		}
+		switch s.When {
+		case "":
+			s.When = WhenOnSuccess
+		case WhenOnSuccess, WhenOnFailure, WhenAlways:
+		default:
+			return fmt.Errorf("step %s has invalid when '%s', must be %s, %s or %s", s.Name, s.When, WhenOnSuccess, WhenOnFailure, WhenAlways)
+		}
+		if s.Job == nil {
+			s.Job = map[string]interface{}{}
+		}
+		steps[s.Name] = s
+	}
+	for _, s := range p.Steps {
+		for _, n := range s.Needs {
+			if steps[n] == nil {
+				return fmt.Errorf("step %s needs unknown step '%s'", s.Name, n)
+			}
+		}
+	}
+
+	// depth first search for cycles
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("steps have a dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, n := range steps[name].Needs {
+			if err := visit(n, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, s := range p.Steps {
+		if err := visit(s.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shouldRun decides whether a step whose needs have all finished should run,
+// and if not why
+func (s PipelineStep) shouldRun(ctx context.Context, results map[string]*StepResult) (bool, string) {
+	if ctx.Err() != nil {
+		return false, ctx.Err().Error()
+	}
+	failed := []string{}
+	for _, n := range s.Needs {
+		if !results[n].Passed() && !results[n].Skipped {
+			failed = append(failed, n)
+		}
+	}
+	notPassed := []string{}
+	for _, n := range s.Needs {
+		if !results[n].Passed() {
+			notPassed = append(notPassed, n)
+		}
+	}
+	switch s.When {
+	case WhenOnFailure:
+		if len(failed) == 0 {
+			return false, "no needed step failed"
+		}
+	case WhenOnSuccess:
+		if len(notPassed) > 0 {
+			return false, fmt.Sprintf("needed step(s) did not succeed: %s", strings.Join(notPassed, ", "))
+		}
+	}
+	return true, ""
+}
+
+// RunPipeline runs the pipeline's steps in dependency order, at most parallel
+// at a time.  progress, if not nil, is called as each step finishes or is
+// skipped.  The results are returned in the order the steps are declared.
+func (cl *Client) RunPipeline(ctx context.Context, p *Pipeline, parallel int, progress func(r StepResult)) []StepResult {
+	return cl.runPipeline(ctx, p, parallel, progress, cl.runStep)
+}
+
+// stepRunner runs a pipeline step, given the outputs of the steps finished
+type stepRunner func(ctx context.Context, p *Pipeline, s PipelineStep, outputs map[string]StepOutput) StepResult
+
+// runPipeline is RunPipeline, running each step with run
+func (cl *Client) runPipeline(ctx context.Context, p *Pipeline, parallel int, progress func(r StepResult), run stepRunner) []StepResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+	const (
+		pending = iota
+		running
+		finished
+	)
+	state := map[string]int{}
+	results := map[string]*StepResult{}
+	done := make(chan StepResult)
+	nRunning := 0
+
+	finish := func(r StepResult) {
+		state[r.Name] = finished
+		results[r.Name] = &r
+		if progress != nil {
+			progress(r)
+		}
+	}
+
+	for {
+		// start, or skip, every step whose needs have all finished
+		scheduled := true
+		for scheduled {
+			scheduled = false
+			for _, s := range p.Steps {
+				if state[s.Name] != pending {
+					continue
+				}
+				ready := true
+				for _, n := range s.Needs {
+					if state[n] != finished {
+						ready = false
+					}
+				}
+				if !ready {
+					continue
+				}
+				if run, reason := s.shouldRun(ctx, results); !run {
+					cl.debug("Skipping pipeline step %s: %s", s.Name, reason)
+					finish(StepResult{
+						BatchResult: BatchResult{Name: s.Name, Started: time.Now()},
+						Skipped:     true,
+						Reason:      reason,
+					})
+					scheduled = true
+					continue
+				}
+				if nRunning >= parallel {
+					continue
+				}
+				state[s.Name] = running
+				nRunning++
+				outputs := stepOutputs(results)
+				go func(s PipelineStep) {
+					done <- run(ctx, p, s, outputs)
+				}(s)
+			}
+		}
+		if nRunning == 0 {
+			break
+		}
+		r := <-done
+		nRunning--
+		finish(r)
+	}
+
+	ordered := make([]StepResult, len(p.Steps))
+	for i, s := range p.Steps {
+		ordered[i] = *results[s.Name]
+	}
+	return ordered
+}
+
+func stepOutputs(results map[string]*StepResult) map[string]StepOutput {
+	outputs := map[string]StepOutput{}
+	for name, r := range results {
+		o := StepOutput{}
+		if r.Response != nil {
+			o = StepOutput{
+				ID:         r.Response.ID,
+				Status:     r.Response.Status,
+				ReturnCode: r.Response.ReturnCode,
+				Output:     r.Response.Output,
+			}
+		} else if r.Skipped {
+			o.Status = "skipped"
+		}
+		outputs[name] = o
+	}
+	return outputs
+}
+
+func (cl *Client) runStep(ctx context.Context, p *Pipeline, s PipelineStep, outputs map[string]StepOutput) StepResult {
+	r := StepResult{BatchResult: BatchResult{Name: s.Name, Started: time.Now()}}
+	defer func() { r.Duration = time.Since(r.Started) }()
+
+	cl.debug("Starting pipeline step %s", s.Name)
+	data := map[string]interface{}{"Steps": outputs}
+	spec, err := expandTemplates(s.Job, data)
+	if err != nil {
+		r.Err = fmt.Errorf("step %s: %s", s.Name, err)
+		return r
+	}
+	req, err := specRequest(spec.(map[string]interface{}), p.base)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.Response, r.Err = cl.RunJob(ctx, req, true)
+	return r
+}
+
+var templateFuncs = template.FuncMap{
+	"trim": strings.TrimSpace,
+	"json": toJSON,
+	"join": strings.Join,
+}
+
+// expandTemplates returns a copy of v with every string expanded as a
+// template over data
+func expandTemplates(v interface{}, data interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		if !strings.Contains(t, "{{") {
+			return t, nil
+		}
+		tmpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(t)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err = tmpl.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		return buf.String(), nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			x, err := expandTemplates(e, data)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = x
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for k, e := range t {
+			x, err := expandTemplates(e, data)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = x
+		}
+		return out, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,145 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPipelineCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		steps []PipelineStep
+		err   string // expected error, "" for none
+	}{
+		{"valid", []PipelineStep{{Name: "a"}, {Name: "b", Needs: []string{"a"}}, {Name: "c", Needs: []string{"a", "b"}}}, ""},
+		{"no steps", nil, "no steps"},
+		{"no name", []PipelineStep{{Name: "a"}, {}}, "step 2 has no name"},
+		{"duplicate", []PipelineStep{{Name: "a"}, {Name: "a"}}, "duplicate step name 'a'"},
+		{"invalid when", []PipelineStep{{Name: "a", When: "sometimes"}}, "invalid when 'sometimes'"},
+		{"unknown need", []PipelineStep{{Name: "a", Needs: []string{"b"}}}, "needs unknown step 'b'"},
+		{"self cycle", []PipelineStep{{Name: "a", Needs: []string{"a"}}}, "dependency cycle: a -> a"},
+		{"cycle", []PipelineStep{
+			{Name: "a", Needs: []string{"c"}},
+			{Name: "b", Needs: []string{"a"}},
+			{Name: "c", Needs: []string{"b"}},
+		}, "dependency cycle: a -> c -> b -> a"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Pipeline{Steps: tc.steps}
+			err := p.check()
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("got %s, want no error", err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Errorf("got %v, want an error containing %q", err, tc.err)
+			}
+		})
+	}
+}
+
+// fakeSteps runs pipeline steps by name, each finishing with the status given
+// in statuses, and checks every needed step finished first
+type fakeSteps struct {
+	t        *testing.T
+	statuses map[string]string
+
+	mu  sync.Mutex
+	ran map[string]bool
+}
+
+func (f *fakeSteps) run(ctx context.Context, p *Pipeline, s PipelineStep, outputs map[string]StepOutput) StepResult {
+	for _, n := range s.Needs {
+		if _, ok := outputs[n]; !ok {
+			f.t.Errorf("step %s ran before step %s it needs", s.Name, n)
+		}
+	}
+	f.mu.Lock()
+	f.ran[s.Name] = true
+	f.mu.Unlock()
+	return StepResult{BatchResult: BatchResult{Name: s.Name, Response: &GetResponse{Status: f.statuses[s.Name]}}}
+}
+
+func TestRunPipelineWhen(t *testing.T) {
+	p := &Pipeline{Steps: []PipelineStep{
+		{Name: "build"},
+		{Name: "test", Needs: []string{"build"}},
+		{Name: "deploy", Needs: []string{"test"}},
+		{Name: "rollback", Needs: []string{"test"}, When: WhenOnFailure},
+		{Name: "notify", Needs: []string{"build"}, When: WhenOnFailure},
+		{Name: "alert", Needs: []string{"deploy"}, When: WhenOnFailure},
+		{Name: "cleanup", Needs: []string{"deploy", "rollback"}, When: WhenAlways},
+	}}
+	if err := p.check(); err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSteps{t: t, ran: map[string]bool{}, statuses: map[string]string{
+		"build": "success", "test": "failed", "rollback": "success", "cleanup": "success",
+	}}
+	results := (&Client{}).runPipeline(context.Background(), p, 2, nil, f.run)
+
+	want := map[string]bool{
+		"build":    true,
+		"test":     true,
+		"deploy":   false, // test failed
+		"rollback": true,  // test failed
+		"notify":   false, // build passed
+		"alert":    false, // deploy was skipped, not failed
+		"cleanup":  true,  // always, once deploy and rollback finished
+	}
+	if len(results) != len(p.Steps) {
+		t.Fatalf("got %d results, want %d", len(results), len(p.Steps))
+	}
+	for i, r := range results {
+		name := p.Steps[i].Name
+		if r.Name != name {
+			t.Errorf("result %d is for step %s, want %s", i, r.Name, name)
+		}
+		if f.ran[name] != want[name] || r.Skipped == want[name] {
+			t.Errorf("step %s ran %t, skipped %t (%s), want ran %t", name, f.ran[name], r.Skipped, r.Reason, want[name])
+		}
+	}
+}
+
+func TestRunPipelineCancelled(t *testing.T) {
+	p := &Pipeline{Steps: []PipelineStep{{Name: "a"}, {Name: "b", Needs: []string{"a"}, When: WhenAlways}}}
+	if err := p.check(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f := &fakeSteps{t: t, ran: map[string]bool{}, statuses: map[string]string{}}
+	results := (&Client{}).runPipeline(ctx, p, 1, nil, f.run)
+	for _, r := range results {
+		if !r.Skipped || f.ran[r.Name] {
+			t.Errorf("step %s ran after the pipeline was cancelled", r.Name)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Vault and gostint api paths used to run a job, see Plan
+const (
+	lookupSelfPath  = "auth/token/lookup-self"
+	tokenCreatePath = "auth/token/create"
+	revokeSelfPath  = "auth/token/revoke-self"
+	cubbyholePath   = "cubbyhole/job"
+	jobPath         = "/v1/api/job"
+)
+
+// Mounts are the paths Vault's AppRole auth method, of both the client and
+// gostint, and transit secrets engine are mounted at
+type Mounts struct {
+	AppRole string
+	Transit string
+}
+
+// DefaultMounts are vault's default mount paths, approle and transit
+func DefaultMounts() Mounts {
+	return Mounts{AppRole: "approle", Transit: "transit"}
+}
+
+// WithMounts sets where vault's AppRole auth method and transit secrets
+// engine are mounted, see DefaultMounts
+func WithMounts(m Mounts) Option {
+	return func(cl *Client) error {
+		m.AppRole = strings.Trim(m.AppRole, "/")
+		m.Transit = strings.Trim(m.Transit, "/")
+		if m.AppRole == "" || m.Transit == "" {
+			return fmt.Errorf("approle and transit mounts must be specified")
+		}
+		cl.mounts = m
+		return nil
+	}
+}
+
+// loginPath is where the client logs in with its AppRole
+func (m Mounts) loginPath() string {
+	return fmt.Sprintf("auth/%s/login", m.AppRole)
+}
+
+// secretIDPath is where a secret id for the gostint role is got from
+func (m Mounts) secretIDPath(role string) string {
+	return fmt.Sprintf("auth/%s/role/%s/secret-id", m.AppRole, role)
+}
+
+// keyPath is the gostint role's transit key
+func (m Mounts) keyPath(role string) string {
+	return fmt.Sprintf("%s/keys/%s", m.Transit, role)
+}
+
+// encryptPath is where a job is encrypted for the gostint role
+func (m Mounts) encryptPath(role string) string {
+	return fmt.Sprintf("%s/encrypt/%s", m.Transit, role)
+}
+
+// decryptPath is where gostint decrypts a job for its role
+func (m Mounts) decryptPath(role string) string {
+	return fmt.Sprintf("%s/decrypt/%s", m.Transit, role)
+}
+
+// Step is a call made to Vault or gostint to run a job
+type Step struct {
+	Service string // "vault" or "gostint"
+	Method  string // HTTP method
+	Path    string
+	Purpose string
+
+	// Capabilities are the vault policy capabilities the client's token
+	// needs on Path, none if the call is unauthenticated, made with another
+	// token or allowed by vault's default policy
+	Capabilities []string
+}
+
+// Plan returns the calls RunSpec makes to Vault and gostint to run a job
+// with the options o, in order, without making any
+func (cl *Client) Plan(o RunOptions) []Step {
+	return plan(cl.mounts, cl.roleID != "", o)
+}
+
+// plan is Plan for a client with the mounts, logging in with an AppRole if
+// appRole.  It must list the calls RunSpec makes, which TestPlanMatchesRunSpec
+// checks.
+func plan(m Mounts, appRole bool, o RunOptions) []Step {
+	role := o.GoStintRole
+	if role == "" {
+		role = "gostint-role"
+	}
+	vault := func(method, path, purpose string, caps ...string) Step {
+		return Step{Service: "vault", Method: method, Path: path, Purpose: purpose, Capabilities: caps}
+	}
+	gostint := func(method, path, purpose string) Step {
+		return Step{Service: "gostint", Method: method, Path: path, Purpose: purpose}
+	}
+
+	steps := []Step{}
+	if appRole {
+		steps = append(steps, vault("POST", m.loginPath(), "log in with the client's AppRole"))
+	}
+	steps = append(steps,
+		vault("GET", lookupSelfPath, "check the client's token"),
+		vault("POST", tokenCreatePath, "create a token, with the default policy, to authenticate with the gostint api", "update"),
+	)
+	if o.IdempotencyKey != "" {
+		steps = append(steps, gostint("GET", jobPath+"?idempotency_key="+url.QueryEscape(o.IdempotencyKey),
+			"look for a job already submitted with the idempotency key"))
+	}
+	steps = append(steps,
+		vault("POST", m.secretIDPath(role), "get a secret id for the gostint role, response wrapped for 1h", "update"),
+		vault("POST", m.encryptPath(role), "encrypt the job with the gostint role's transit key", "update"),
+		vault("POST", tokenCreatePath, "create a token, limited to 2 uses and 60m, to pass the job in its cubbyhole", "update"),
+		vault("POST", cubbyholePath, "put the encrypted job in the cubbyhole, with the cubbyhole token"),
+		gostint("POST", jobPath, "submit the job, wrapping the cubbyhole token and secret id"),
+	)
+	if o.Wait {
+		steps = append(steps, gostint("GET", jobPath+"/<id>", "poll the job until it completes"))
+	} else {
+		steps = append(steps, gostint("GET", jobPath+"/<id>", "get the job's state"))
+	}
+	steps = append(steps, vault("POST", revokeSelfPath, "revoke the gostint api token, with that token"))
+	return steps
+}
+
+// goStintPlan returns the calls gostint makes to Vault to run the jobs the
+// client submits for the gostint role that need the role's policy: decrypting
+// the job, then reading the secret refs of each of specs
+func goStintPlan(m Mounts, role string, specs ...*JobSpec) []Step {
+	if role == "" {
+		role = "gostint-role"
+	}
+	steps := []Step{{
+		Service: "vault", Method: "POST", Path: m.decryptPath(role),
+		Purpose: "decrypt jobs with the gostint role's transit key", Capabilities: []string{"update"},
+	}}
+	for _, spec := range specs {
+		for _, ref := range spec.SecretRefs {
+			at, dot := strings.Index(ref, "@"), strings.LastIndex(ref, ".")
+			if at < 0 || dot < at {
+				continue
+			}
+			steps = append(steps, Step{
+				Service: "vault", Method: "GET", Path: ref[at+1 : dot],
+				Purpose: "read secret ref " + ref[:at], Capabilities: []string{"read"},
+			})
+		}
+	}
+	return steps
+}
+
+// StepURL returns the URL a step of a Plan calls
+func (cl *Client) StepURL(s Step) string {
+	if s.Service == "vault" {
+		return strings.TrimSuffix(cl.vaultURL, "/") + "/v1/" + s.Path
+	}
+	return strings.TrimSuffix(cl.url, "/") + s.Path
+}
+
+// Elided returns a copy of the job that is safe to show: its encoded content
+// and the values of its env_vars, which may be secret, replaced by their size
+func (s JobSpec) Elided() JobSpec {
+	if strings.HasPrefix(s.Content, "targz,") {
+		s.Content = fmt.Sprintf("targz,(%d bytes elided)", len(s.Content)-len("targz,"))
+	}
+	if len(s.EnvVars) > 0 {
+		envVars := make([]string, len(s.EnvVars))
+		for i, e := range s.EnvVars {
+			name, value := e, ""
+			if eq := strings.Index(e, "="); eq >= 0 {
+				name, value = e[:eq], e[eq+1:]
+			}
+			envVars[i] = fmt.Sprintf("%s=(%d bytes elided)", name, len(value))
+		}
+		s.EnvVars = envVars
+	}
+	return s
+}
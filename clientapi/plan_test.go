@@ -0,0 +1,99 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanMatchesRunSpec(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		appRole bool
+		o       RunOptions
+	}{
+		{"token", false, RunOptions{Wait: true}},
+		{"approle", true, RunOptions{Wait: true}},
+		{"no wait", false, RunOptions{}},
+		{"idempotency key", false, RunOptions{IdempotencyKey: "key-1", Wait: true}},
+		{"gostint role", true, RunOptions{GoStintRole: "other-role"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &recorder{}
+			vault := startFakeVault(t, &fakeVault{rec: rec})
+			gostint := startFakeGostint(t, &fakeGostint{rec: rec})
+
+			auth := WithVaultToken("root")
+			if tc.appRole {
+				auth = WithAppRole("role-id", "secret-id")
+			}
+			cl, err := NewClient(WithURL(gostint.URL), WithVaultURL(vault.URL), auth,
+				WithMounts(Mounts{AppRole: "my-approle", Transit: "my-transit"}), WithJournal(t.TempDir()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = cl.RunSpec(context.Background(), &JobSpec{ContainerImage: "alpine"}, tc.o); err != nil {
+				t.Fatal(err)
+			}
+			want := []string{}
+			for _, s := range cl.Plan(tc.o) {
+				want = append(want, fmt.Sprintf("%s %s %s", s.Service, s.Method, s.Path))
+			}
+			if calls := rec.Calls(); !reflect.DeepEqual(calls, want) {
+				t.Errorf("RunSpec called\n  %s\nbut Plan is\n  %s", strings.Join(calls, "\n  "), strings.Join(want, "\n  "))
+			}
+		})
+	}
+}
+
+func TestElided(t *testing.T) {
+	spec := JobSpec{
+		ContainerImage: "alpine",
+		Content:        "targz,H4sIAAAAAAAA",
+		EnvVars:        []string{"PASSWORD=hunter2", "EMPTY"},
+	}
+	got := spec.Elided()
+	if got.Content != "targz,(12 bytes elided)" {
+		t.Errorf("got content %q", got.Content)
+	}
+	if want := []string{"PASSWORD=(7 bytes elided)", "EMPTY=(0 bytes elided)"}; !reflect.DeepEqual(got.EnvVars, want) {
+		t.Errorf("got env vars %v, want %v", got.EnvVars, want)
+	}
+	if spec.EnvVars[0] != "PASSWORD=hunter2" {
+		t.Error("elided the job's own env vars")
+	}
+
+	spec.Content = "/srv/play"
+	if got := spec.Elided(); got.Content != "/srv/play" {
+		t.Errorf("elided a content path to %q", got.Content)
+	}
+}
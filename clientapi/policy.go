@@ -0,0 +1,102 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ClientPolicy returns the minimal vault policy, as HCL, the client's token
+// needs to run jobs on the gostint role, derived from the calls RunSpec makes
+// (see Plan) so the two can't diverge
+func ClientPolicy(m Mounts, role string) string {
+	if role == "" {
+		role = "gostint-role"
+	}
+	return policyHCL(
+		fmt.Sprintf("Policy for gostint-client to run jobs on the gostint role %s", role),
+		plan(m, true, RunOptions{GoStintRole: role}),
+	)
+}
+
+// GoStintPolicy returns the vault policy, as HCL, the gostint role needs for
+// the jobs the client submits: decrypting them with the role's transit key
+// and reading the secret refs of each of specs
+func GoStintPolicy(m Mounts, role string, specs ...*JobSpec) string {
+	if role == "" {
+		role = "gostint-role"
+	}
+	return policyHCL(
+		fmt.Sprintf("Policy for the gostint role %s to run jobs submitted by gostint-client", role),
+		goStintPlan(m, role, specs...),
+	)
+}
+
+// policyHCL returns a policy granting the capabilities of the vault steps,
+// each path once, in order, commented with why it is needed
+func policyHCL(title string, steps []Step) string {
+	paths := []string{}
+	caps := map[string][]string{}
+	why := map[string][]string{}
+	for _, st := range steps {
+		if st.Service != "vault" || len(st.Capabilities) == 0 {
+			continue
+		}
+		if _, ok := caps[st.Path]; !ok {
+			paths = append(paths, st.Path)
+		}
+		for _, c := range st.Capabilities {
+			if !contains(caps[st.Path], c) {
+				caps[st.Path] = append(caps[st.Path], c)
+			}
+		}
+		if !contains(why[st.Path], st.Purpose) {
+			why[st.Path] = append(why[st.Path], st.Purpose)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s,\n# generated by 'gostint-client policy generate'\n", title)
+	for _, p := range paths {
+		b.WriteString("\n")
+		for _, w := range why[p] {
+			fmt.Fprintf(&b, "# %s\n", w)
+		}
+		fmt.Fprintf(&b, "path \"%s\" {\n  capabilities = [\"%s\"]\n}\n", p, strings.Join(caps[p], "\", \""))
+	}
+	return b.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Errors returned when waiting on a job exceeds a deadline of the PollPolicy
+var (
+	ErrWaitDeadline    = errors.New("job did not complete within the wait deadline")
+	ErrQueuedDeadline  = errors.New("job was queued for longer than the queued deadline")
+	ErrRunningDeadline = errors.New("job was running for longer than the running deadline")
+)
+
+// PollPolicy controls how a job's state is polled while waiting for it to
+// complete.  The interval starts at Interval and is multiplied by Multiplier
+// after each poll, up to MaxInterval, each sleep being randomised by +/-
+// Jitter (a fraction, e.g. 0.2 for 20%).  Zero deadlines do not apply.
+type PollPolicy struct {
+	Interval        time.Duration
+	Multiplier      float64
+	MaxInterval     time.Duration
+	Jitter          float64
+	Deadline        time.Duration // overall time to wait
+	QueuedDeadline  time.Duration // time the job may wait in the queue
+	RunningDeadline time.Duration // time the job may run for
+}
+
+// DefaultPollPolicy starts polling every second, backing off to every 10
+// seconds, without deadlines
+func DefaultPollPolicy() PollPolicy {
+	return PollPolicy{
+		Interval:    time.Second,
+		Multiplier:  1.5,
+		MaxInterval: 10 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// FixedPollPolicy polls at a fixed interval, without deadlines
+func FixedPollPolicy(interval time.Duration) PollPolicy {
+	return PollPolicy{
+		Interval:    interval,
+		Multiplier:  1,
+		MaxInterval: interval,
+	}
+}
+
+// WithPollPolicy sets how jobs are polled while waiting for them
+func WithPollPolicy(p PollPolicy) Option {
+	return func(cl *Client) error {
+		if p.Interval <= 0 {
+			return fmt.Errorf("poll interval must be greater than zero")
+		}
+		if p.Multiplier < 1 {
+			return fmt.Errorf("poll backoff multiplier must be at least 1")
+		}
+		if p.Jitter < 0 || p.Jitter >= 1 {
+			return fmt.Errorf("poll jitter must be between 0 and 1")
+		}
+		if p.MaxInterval < p.Interval {
+			p.MaxInterval = p.Interval
+		}
+		cl.poll = p
+		return nil
+	}
+}
+
+// next returns the interval to use after current
+func (p PollPolicy) next(current time.Duration) time.Duration {
+	return backoff(current, p.Multiplier, p.MaxInterval)
+}
+
+// jitter randomises an interval by the policy's jitter fraction
+func (p PollPolicy) jitter(d time.Duration) time.Duration {
+	return jitter(d, p.Jitter)
+}
+
+// jobDone returns true once a job has left the queued and running states
+func jobDone(r *GetResponse) bool {
+	return r.Status != "queued" && r.Status != "running"
+}
+
+type streamResult struct {
+	written   int
+	supported bool
+	err       error
+}
+
+// WaitJob waits for a job to complete, polling per the client's PollPolicy.
+// If w is not nil the job's output is written to it incrementally as it is
+// produced, using the gostint streaming endpoint when the server offers one,
+// otherwise writing only the new bytes of Output seen on each poll.  Transient
+// failures polling the job (see IsRetryable) do not end the wait.  Exceeding
+// a deadline of the policy returns ErrWaitDeadline, ErrQueuedDeadline or
+// ErrRunningDeadline.
+func (cl *Client) WaitJob(ctx context.Context, token string, ID string, w io.Writer) (*GetResponse, error) {
+	p := cl.poll
+	start := time.Now()
+	var runningSince time.Time
+
+	// follow the stream alongside polling, which still tracks the job's state
+	// and deadlines, until the stream ends
+	written := 0
+	var streamDone chan streamResult
+	streamCtx, stopStream := context.WithCancel(ctx)
+	defer stopStream()
+	if w != nil {
+		streamDone = make(chan streamResult, 1)
+		go func() {
+			n, supported, err := cl.streamJob(streamCtx, token, ID, w)
+			streamDone <- streamResult{n, supported, err}
+		}()
+	}
+	streamEnded := func(r streamResult) {
+		written = r.written
+		streamDone = nil
+		if r.err != nil && ctx.Err() == nil {
+			cl.debug("Output stream ended with error, falling back to polling: %s", r.err)
+		} else if !r.supported {
+			cl.debug("Output streaming not supported by server, falling back to polling")
+		}
+	}
+
+	interval := p.Interval
+	var last *GetResponse
+	for {
+		getResp, err := cl.GetJob(ctx, token, ID)
+		if err != nil {
+			if ctx.Err() != nil || !IsRetryable(err) {
+				return last, fmt.Errorf("job %s: %w", ID, err)
+			}
+			// the job is still out there, keep polling through the outage
+			cl.debug("Polling job %s failed, will poll again: %s", ID, err)
+		} else {
+			last = getResp
+
+			if streamDone != nil && jobDone(getResp) {
+				// give the stream a moment to deliver the job's final output
+				select {
+				case r := <-streamDone:
+					streamEnded(r)
+				case <-time.After(5 * time.Second):
+					stopStream()
+					streamEnded(<-streamDone)
+				}
+			} else if streamDone != nil {
+				select {
+				case r := <-streamDone:
+					streamEnded(r)
+				default:
+				}
+			}
+			if w != nil && streamDone == nil && len(getResp.Output) > written {
+				if _, err = io.WriteString(w, getResp.Output[written:]); err != nil {
+					return getResp, err
+				}
+				written = len(getResp.Output)
+			}
+
+			if jobDone(getResp) {
+				return getResp, nil
+			}
+		}
+
+		// deadlines are judged on the last state seen
+		now := time.Now()
+		status := ""
+		if last != nil {
+			status = last.Status
+		}
+		if status == "running" && runningSince.IsZero() {
+			runningSince = now
+		}
+		switch {
+		case p.Deadline > 0 && now.Sub(start) >= p.Deadline:
+			return last, fmt.Errorf("job %s: %w (%s)", ID, ErrWaitDeadline, p.Deadline)
+		case status == "queued" && p.QueuedDeadline > 0 && now.Sub(start) >= p.QueuedDeadline:
+			return last, fmt.Errorf("job %s: %w (%s)", ID, ErrQueuedDeadline, p.QueuedDeadline)
+		case status == "running" && p.RunningDeadline > 0 && now.Sub(runningSince) >= p.RunningDeadline:
+			return last, fmt.Errorf("job %s: %w (%s)", ID, ErrRunningDeadline, p.RunningDeadline)
+		}
+
+		d := p.jitter(interval)
+		if p.Deadline > 0 {
+			if remaining := p.Deadline - now.Sub(start); d > remaining {
+				d = remaining
+			}
+		}
+		if err = sleep(ctx, d); err != nil {
+			return last, err
+		}
+		interval = p.next(interval)
+	}
+}
+
+// WaitJobOrKill is WaitJob, but kills the job in gostint if ctx is cancelled
+// or times out, or a deadline of the PollPolicy is exceeded, before the job
+// completes
+func (cl *Client) WaitJobOrKill(ctx context.Context, token string, ID string, w io.Writer) (*GetResponse, error) {
+	getResp, err := cl.WaitJob(ctx, token, ID, w)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return getResp, cl.killCancelled(ctx, token, ID)
+	case errors.Is(err, ErrWaitDeadline), errors.Is(err, ErrQueuedDeadline), errors.Is(err, ErrRunningDeadline):
+		return getResp, cl.killUnfinished(token, ID, err)
+	}
+	return getResp, err
+}
@@ -0,0 +1,144 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWithPollPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		p    PollPolicy
+		ok   bool
+	}{
+		{"default", DefaultPollPolicy(), true},
+		{"fixed", FixedPollPolicy(time.Second), true},
+		{"zero interval", PollPolicy{Multiplier: 1}, false},
+		{"negative interval", PollPolicy{Interval: -time.Second, Multiplier: 1}, false},
+		{"shrinking", PollPolicy{Interval: time.Second, Multiplier: 0.5}, false},
+		{"negative jitter", PollPolicy{Interval: time.Second, Multiplier: 1, Jitter: -0.1}, false},
+		{"whole jitter", PollPolicy{Interval: time.Second, Multiplier: 1, Jitter: 1}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewClient(WithURL("http://gostint"), WithPollPolicy(tc.p))
+			if (err == nil) != tc.ok {
+				t.Errorf("got error %v, want ok %t", err, tc.ok)
+			}
+		})
+	}
+
+	cl, err := NewClient(WithURL("http://gostint"), WithPollPolicy(PollPolicy{Interval: time.Second, Multiplier: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.poll.MaxInterval != time.Second {
+		t.Errorf("max interval got %s, want it raised to the interval", cl.poll.MaxInterval)
+	}
+}
+
+func TestPollBackoff(t *testing.T) {
+	p := DefaultPollPolicy()
+	var got []time.Duration
+	for d := p.Interval; len(got) < 8; d = p.next(d) {
+		got = append(got, d)
+	}
+	want := []time.Duration{
+		time.Second, 1500 * time.Millisecond, 2250 * time.Millisecond, 3375 * time.Millisecond,
+		5062500 * time.Microsecond, 7593750 * time.Microsecond, 10 * time.Second, 10 * time.Second,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	f := FixedPollPolicy(time.Second)
+	if d := f.next(time.Second); d != time.Second {
+		t.Errorf("fixed policy backed off to %s", d)
+	}
+	if d := f.jitter(time.Second); d != time.Second {
+		t.Errorf("fixed policy jittered to %s", d)
+	}
+}
+
+func TestWaitJob(t *testing.T) {
+	ms := time.Millisecond
+	for _, tc := range []struct {
+		name     string
+		statuses []string
+		p        PollPolicy
+		status   string // of the job returned
+		err      error
+	}{
+		{"success", []string{"queued", "running", "success"}, FixedPollPolicy(ms), "success", nil},
+		{"failed", []string{"running", "failed"}, FixedPollPolicy(ms), "failed", nil},
+		{"outage", []string{"queued", "", "", "success"}, FixedPollPolicy(ms), "success", nil},
+		{"wait deadline", []string{"running"}, PollPolicy{Interval: ms, Multiplier: 1, Deadline: 30 * ms}, "running", ErrWaitDeadline},
+		{"queued deadline", []string{"queued"}, PollPolicy{Interval: ms, Multiplier: 1, QueuedDeadline: 30 * ms}, "queued", ErrQueuedDeadline},
+		{"running deadline", []string{"queued", "running"}, PollPolicy{Interval: ms, Multiplier: 1, QueuedDeadline: time.Hour, RunningDeadline: 30 * ms}, "running", ErrRunningDeadline},
+		{"down past deadline", []string{"queued", ""}, PollPolicy{Interval: ms, Multiplier: 1, Deadline: 30 * ms}, "queued", ErrWaitDeadline},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := startFakeGostint(t, &fakeGostint{statuses: tc.statuses})
+			ID := g.addJob("")
+			r := DefaultRetryPolicy()
+			r.Interval = ms
+			cl, err := NewClient(WithURL(g.URL), WithPollPolicy(tc.p), WithRetryPolicy(r))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			getResp, err := cl.WaitJob(ctx, "token", ID, nil)
+			if !errors.Is(err, tc.err) || (tc.err == nil) != (err == nil) {
+				t.Errorf("got error %v, want %v", err, tc.err)
+			}
+			if getResp == nil || getResp.Status != tc.status {
+				t.Errorf("got job %v, want status %s", getResp, tc.status)
+			}
+		})
+	}
+}
+
+func TestWaitJobNotFound(t *testing.T) {
+	g := startFakeGostint(t, &fakeGostint{})
+	cl, err := NewClient(WithURL(g.URL), WithPollPolicy(FixedPollPolicy(time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cl.WaitJob(context.Background(), "token", "job-2", nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("got error %v, want a 404", err)
+	}
+}
@@ -0,0 +1,287 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// RetryPolicy controls how calls to gostint and Vault that fail with a
+// transient error (see IsRetryable) are retried.  The first retry waits
+// Interval, multiplied by Multiplier for each further retry up to MaxInterval,
+// each wait being randomised by +/- Jitter (a fraction).  A Retry-After sent by
+// the server is honoured if longer.
+type RetryPolicy struct {
+	Retries     int // retries after the first attempt, 0 disables retrying
+	Interval    time.Duration
+	Multiplier  float64
+	MaxInterval time.Duration
+	Jitter      float64
+}
+
+// DefaultRetryPolicy retries 4 times, waiting from half a second up to 15
+// seconds between attempts
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Retries:     4,
+		Interval:    500 * time.Millisecond,
+		Multiplier:  2,
+		MaxInterval: 15 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// WithRetryPolicy sets how transient failures are retried
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(cl *Client) error {
+		if p.Retries < 0 {
+			return fmt.Errorf("retries must not be negative")
+		}
+		if p.Retries > 0 && p.Interval <= 0 {
+			return fmt.Errorf("retry interval must be greater than zero")
+		}
+		if p.Multiplier < 1 {
+			return fmt.Errorf("retry backoff multiplier must be at least 1")
+		}
+		if p.Jitter < 0 || p.Jitter >= 1 {
+			return fmt.Errorf("retry jitter must be between 0 and 1")
+		}
+		if p.MaxInterval < p.Interval {
+			p.MaxInterval = p.Interval
+		}
+		cl.retries = p
+		return nil
+	}
+}
+
+// backoff returns the interval to use after current
+func backoff(current time.Duration, multiplier float64, max time.Duration) time.Duration {
+	n := time.Duration(float64(current) * multiplier)
+	if n > max {
+		n = max
+	}
+	return n
+}
+
+// jitter randomises an interval by +/- fraction j
+func jitter(d time.Duration, j float64) time.Duration {
+	if j == 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + j*(rand.Float64()*2-1)))
+}
+
+// retryStatus returns true for http statuses that are worth retrying
+func retryStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+		473: // vault performance standby
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header of either delay seconds or an
+// http date, returning 0 if absent or invalid
+func parseRetryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// retryAfterKey is the context key of where vaultTransport records the
+// Retry-After of a Vault response, which the Vault api leaves out of its errors
+type retryAfterKey struct{}
+
+// vaultTransport records the Retry-After of each Vault response in its
+// request's context
+type vaultTransport struct {
+	http.RoundTripper
+}
+
+func (t vaultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if d, ok := req.Context().Value(retryAfterKey{}).(*time.Duration); ok && resp != nil {
+		*d = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return resp, err
+}
+
+// IsRetryable returns true if err is a transient failure that may succeed if
+// the call is retried: network errors, http statuses 429, 502, 503 and 504,
+// and a sealed or standby Vault.  Cancellation and TLS verification failures
+// are not retryable.
+func IsRetryable(err error) bool {
+	return retryable(err, true)
+}
+
+// retryable classifies err.  A call that is not idempotent is only retried if
+// it certainly did not reach the server, i.e. the connection could not be
+// made or the server refused it with a retryable status other than a
+// gateway's 502 or 504.
+func retryable(err error, idempotent bool) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var ae *APIError
+	if errors.As(err, &ae) {
+		// a gateway may time out, or lose the response, after passing the
+		// call on to gostint
+		if !idempotent && (ae.StatusCode == http.StatusBadGateway || ae.StatusCode == http.StatusGatewayTimeout) {
+			return false
+		}
+		return retryStatus(ae.StatusCode)
+	}
+	var re *api.ResponseError
+	if errors.As(err, &re) {
+		if retryStatus(re.StatusCode) {
+			return true
+		}
+		msg := strings.Join(re.Errors, " ")
+		return strings.Contains(msg, "Vault is sealed") || strings.Contains(msg, "standby")
+	}
+
+	// certificate problems won't fix themselves
+	var (
+		unknownCA x509.UnknownAuthorityError
+		hostname  x509.HostnameError
+		invalid   x509.CertificateInvalidError
+		recordHdr tls.RecordHeaderError
+		verifyErr *tls.CertificateVerificationError
+		urlErr    *url.Error
+		opErr     *net.OpError
+		netErr    net.Error
+	)
+	if errors.As(err, &unknownCA) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		errors.As(err, &recordHdr) || errors.As(err, &verifyErr) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	if errors.As(err, &opErr) {
+		if opErr.Op == "dial" {
+			return true
+		}
+		if opErr.Op == "remote error" { // a tls alert from the server
+			return false
+		}
+		return idempotent
+	}
+	if !idempotent {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	// a url.Error is itself a net.Error, so judge by what it wraps
+	if errors.As(err, &urlErr) {
+		return errors.As(urlErr.Err, &netErr) && netErr.Timeout()
+	}
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// retryable, or the client's RetryPolicy is exhausted, returning fn's last
+// error.  fn is passed ctx, with where to record a Retry-After from Vault.
+// what describes the call in debug logging.
+func (cl *Client) retry(ctx context.Context, what string, idempotent bool, fn func(ctx context.Context) error) error {
+	p := cl.retries
+	interval := p.Interval
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		err := fn(context.WithValue(ctx, retryAfterKey{}, &retryAfter))
+		if err == nil || attempt >= p.Retries || ctx.Err() != nil || !retryable(err, idempotent) {
+			return err
+		}
+
+		var ae *APIError
+		if errors.As(err, &ae) {
+			retryAfter = ae.RetryAfter
+		}
+		d := jitter(interval, p.Jitter)
+		if retryAfter > d {
+			d = retryAfter
+		}
+		cl.debug("%s failed, retry %d of %d in %s: %s", what, attempt+1, p.Retries, d.Round(time.Millisecond), err)
+		if sleep(ctx, d) != nil {
+			return err
+		}
+		interval = backoff(interval, p.Multiplier, p.MaxInterval)
+	}
+}
+
+// vaultRead is a Vault logical read, retrying transient failures
+func (cl *Client) vaultRead(ctx context.Context, vc *api.Client, path string) (*api.Secret, error) {
+	var sec *api.Secret
+	err := cl.retry(ctx, "vault read "+path, true, func(ctx context.Context) (err error) {
+		sec, err = vc.Logical().ReadWithContext(ctx, path)
+		return err
+	})
+	return sec, err
+}
+
+// vaultWrite is a Vault logical write, retrying transient failures.  Only use
+// it for writes that are safe to repeat, at worst leaving an unused token or
+// secret-id to expire, not for writes made with a use limited token.
+func (cl *Client) vaultWrite(ctx context.Context, vc *api.Client, path string, data map[string]interface{}) (*api.Secret, error) {
+	var sec *api.Secret
+	err := cl.retry(ctx, "vault write "+path, true, func(ctx context.Context) (err error) {
+		sec, err = vc.Logical().WriteWithContext(ctx, path, data)
+		return err
+	})
+	return sec, err
+}
@@ -0,0 +1,227 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryable(t *testing.T) {
+	urlErr := func(err error) error { return &url.Error{Op: "Post", URL: "https://gostint/v1/api/job", Err: err} }
+	for _, tc := range []struct {
+		name          string
+		err           error
+		idempotent    bool // retried if idempotent
+		notIdempotent bool // retried if not idempotent
+		ambiguous     bool // a submission may have been accepted
+	}{
+		{"nil", nil, false, false, false},
+		{"cancelled", urlErr(context.Canceled), false, false, false},
+		{"timed out", fmt.Errorf("job: %w", context.DeadlineExceeded), false, false, false},
+		{"429", &APIError{StatusCode: 429}, true, true, false},
+		{"503", &APIError{StatusCode: 503}, true, true, false},
+		{"502", &APIError{StatusCode: 502}, true, false, true},
+		{"504", &APIError{StatusCode: 504}, true, false, true},
+		{"wrapped 504", fmt.Errorf("submit: %w", &APIError{StatusCode: 504}), true, false, true},
+		{"500", &APIError{StatusCode: 500}, false, false, false},
+		{"400", &APIError{StatusCode: 400}, false, false, false},
+		{"404", &APIError{StatusCode: 404}, false, false, false},
+		{"vault 503", &api.ResponseError{StatusCode: 503}, true, true, false},
+		{"vault 473", &api.ResponseError{StatusCode: 473}, true, true, false},
+		{"vault sealed", &api.ResponseError{StatusCode: 500, Errors: []string{"Vault is sealed"}}, true, true, false},
+		{"vault 403", &api.ResponseError{StatusCode: 403, Errors: []string{"permission denied"}}, false, false, false},
+		{"connection refused", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), true, true, false},
+		{"dial failed", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")}), true, true, false},
+		{"connection reset", urlErr(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true, false, true},
+		{"eof", urlErr(io.EOF), true, false, true},
+		{"unexpected eof", urlErr(io.ErrUnexpectedEOF), true, false, true},
+		{"timeout", urlErr(timeoutError{}), true, false, true},
+		{"unknown ca", urlErr(x509.UnknownAuthorityError{}), false, false, false},
+		{"hostname", urlErr(x509.HostnameError{Certificate: &x509.Certificate{}, Host: "gostint"}), false, false, false},
+		{"tls alert", urlErr(&net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}), false, false, false},
+		{"other", errors.New("something else"), false, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := retryable(tc.err, true); got != tc.idempotent {
+				t.Errorf("retryable idempotent got %t, want %t", got, tc.idempotent)
+			}
+			if got := retryable(tc.err, false); got != tc.notIdempotent {
+				t.Errorf("retryable not idempotent got %t, want %t", got, tc.notIdempotent)
+			}
+			if got := IsRetryable(tc.err); got != tc.idempotent {
+				t.Errorf("IsRetryable got %t, want %t", got, tc.idempotent)
+			}
+			if got := ambiguous(tc.err); got != tc.ambiguous {
+				t.Errorf("ambiguous got %t, want %t", got, tc.ambiguous)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		h    string
+		want time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"-1", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	} {
+		if got := parseRetryAfter(tc.h); got != tc.want {
+			t.Errorf("%q: got %s, want %s", tc.h, got, tc.want)
+		}
+	}
+	if got := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("got %s for a date an hour away", got)
+	}
+}
+
+func TestBackoffAndJitter(t *testing.T) {
+	if got := backoff(time.Second, 2, 3*time.Second); got != 2*time.Second {
+		t.Errorf("backoff got %s, want 2s", got)
+	}
+	if got := backoff(2*time.Second, 2, 3*time.Second); got != 3*time.Second {
+		t.Errorf("backoff got %s, want it capped at 3s", got)
+	}
+	if got := jitter(time.Second, 0); got != time.Second {
+		t.Errorf("jitter 0 got %s, want 1s", got)
+	}
+	for i := 0; i < 100; i++ {
+		if got := jitter(time.Second, 0.2); got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("jitter 0.2 got %s, want within 20%% of 1s", got)
+		}
+	}
+}
+
+func TestSubmitRetries(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		posts  int // submissions made
+	}{
+		{http.StatusServiceUnavailable, 2}, // refused, so resubmitted
+		{http.StatusTooManyRequests, 2},
+		{http.StatusBadGateway, 1}, // may have been accepted, so looked for
+		{http.StatusGatewayTimeout, 1},
+	} {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			// a gateway's error may come after gostint accepted the job
+			g := startFakeGostint(t, &fakeGostint{submit: func(n int, w http.ResponseWriter, r *http.Request) bool {
+				if n == 1 {
+					w.WriteHeader(tc.status)
+				}
+				return n == 1
+			}})
+			p := DefaultRetryPolicy()
+			p.Interval = time.Millisecond
+			cl, err := NewClient(WithURL(g.URL), WithJournal(t.TempDir()), WithRetryPolicy(p))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ID, err := cl.submitOrFind(context.Background(), []byte(`{}`), "token", "key-1", true)
+			if err != nil || ID != "job-1" {
+				t.Errorf("got %q, %v, want job-1", ID, err)
+			}
+			if n := g.Submits(); n != tc.posts {
+				t.Errorf("job submitted %d times, want %d", n, tc.posts)
+			}
+		})
+	}
+}
+
+// failFirst fails the first call to path with status
+func failFirst(path string, status int) func(string, int) int {
+	return func(p string, n int) int {
+		if p == path && n == 1 {
+			return status
+		}
+		return 0
+	}
+}
+
+func TestVaultRetryAfter(t *testing.T) {
+	v := startFakeVault(t, &fakeVault{fail: failFirst(lookupSelfPath, http.StatusServiceUnavailable), retryAfter: "1"})
+	p := DefaultRetryPolicy()
+	p.Interval = time.Millisecond
+	cl, err := NewClient(WithURL("http://gostint"), WithVaultURL(v.URL), WithVaultToken("root"), WithRetryPolicy(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err = cl.vaultClient(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("retried after %s, want vault's Retry-After of 1s", d)
+	}
+}
+
+func TestCubbyholeRevokesFailedTokens(t *testing.T) {
+	v := startFakeVault(t, &fakeVault{fail: failFirst(cubbyholePath, http.StatusServiceUnavailable)})
+	p := DefaultRetryPolicy()
+	p.Interval = time.Millisecond
+	cl, err := NewClient(WithURL("http://gostint"), WithVaultURL(v.URL), WithVaultToken("root"), WithRetryPolicy(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vc, err := cl.vaultClient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := cl.cubbyhole(context.Background(), vc, "vault:v1:abc")
+	if err != nil || token != "token-2" {
+		t.Errorf("got %q, %v, want the retry's token-2", token, err)
+	}
+	if got := v.Revoked(); len(got) != 1 || got[0] != "token-1" {
+		t.Errorf("revoked %q, want the failed attempt's token-1", got)
+	}
+}
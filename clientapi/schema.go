@@ -0,0 +1,141 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// JobSchemaID identifies the job JSON Schema, and is where the copy published
+// with the client can be fetched from
+const JobSchemaID = "https://raw.githubusercontent.com/goethite/gostint-client/master/job.schema.json"
+
+// Schema is the subset of JSON Schema used to describe a job
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	ID          string             `json:"$id,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+
+	// AdditionalProperties is whether properties not listed in Properties
+	// are allowed, or their *Schema
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+
+	// PatternErrorMessage describes Pattern, shown by VS Code when a value
+	// doesn't match it
+	PatternErrorMessage string `json:"patternErrorMessage,omitempty"`
+}
+
+// jobFieldDocs describe the JobSpec fields, by json field name
+var jobFieldDocs = map[string]string{
+	"qname":             "Job queue to submit to",
+	"container_image":   "Docker image to run the job within",
+	"image_pull_policy": "Docker image pull policy",
+	"content":           "Content to inject into the container, a folder or tar.gz file that the client encodes as 'targz,<base64>'",
+	"entrypoint":        "The container's entrypoint, as a list of string parts",
+	"run":               "Command to run in the container, as a list of string parts",
+	"working_directory": "Working directory within the container to run the job",
+	"env_vars":          "Environment variables for the job's container",
+	"secret_refs":       "Vault secrets to inject into the job's container",
+	"secret_file_type":  "File type of the injected secrets",
+	"cont_on_warnings":  "Run the job even if vault reported warnings looking up its secret_refs",
+}
+
+// jobSchema is the job JSON Schema, whose properties jobs are validated
+// against
+var jobSchema = JobSchema(false)
+
+// JobSchema returns the JSON Schema of a job file, generated from JobSpec
+// and its enums and formats, for editors to complete and validate job files
+// with.  Fields unknown to the client are allowed, as they are passed to
+// gostint unchanged, unless strict.
+func JobSchema(strict bool) *Schema {
+	s := &Schema{
+		Schema:      "http://json-schema.org/draft-07/schema#",
+		ID:          JobSchemaID,
+		Title:       "gostint job",
+		Description: "A job for gostint to run in a container, as submitted by gostint-client",
+		Type:        "object",
+		Properties: map[string]*Schema{
+			"$schema": {
+				Type:        "string",
+				Description: "JSON Schema of the job, for editors - not sent to gostint",
+			},
+			"matrix": {
+				Type:                 "object",
+				Description:          "Run the job once per combination of the values listed for these job fields, with include and exclude lists of combinations to add and drop",
+				AdditionalProperties: &Schema{Type: "array"},
+			},
+		},
+		AdditionalProperties: !strict,
+	}
+
+	t := reflect.TypeOf(JobSpec{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		p := &Schema{Description: jobFieldDocs[name]}
+		switch t.Field(i).Type.Kind() {
+		case reflect.String:
+			p.Type = "string"
+			p.Enum = jobFieldEnums[name]
+		case reflect.Slice:
+			p.Type = "array"
+			p.Items = &Schema{Type: "string"}
+			if f, ok := jobItemFormats[name]; ok {
+				p.Items.Pattern = f.re.String()
+				p.Items.PatternErrorMessage = "must be " + f.desc
+			}
+		case reflect.Bool:
+			p.Type = "boolean"
+		}
+		s.Properties[name] = p
+	}
+	return s
+}
+
+// JobSchemaJSON returns the job JSON Schema, indented
+func JobSchemaJSON(strict bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(JobSchema(strict)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,56 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestJobSchemaFileIsGenerated(t *testing.T) {
+	got, err := ioutil.ReadFile("../job.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := JobSchemaJSON(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("job.schema.json is out of date with JobSpec, regenerate it with go generate")
+	}
+}
+
+func TestJobSchemaStrict(t *testing.T) {
+	if got := JobSchema(false).AdditionalProperties; got != true {
+		t.Errorf("got additionalProperties %v, want true", got)
+	}
+	if got := JobSchema(true).AdditionalProperties; got != false {
+		t.Errorf("got additionalProperties %v when strict, want false", got)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// streamJob follows the job's server-sent event stream, writing output events
+// to w until the stream ends.  It returns the number of bytes written and
+// whether the server supports streaming.
+func (cl *Client) streamJob(ctx context.Context, token string, ID string, w io.Writer) (int, bool, error) {
+	cl.debug("Streaming job output")
+	req, err := http.NewRequest(
+		"GET",
+		fmt.Sprintf("%s/v1/api/job/%s/stream", cl.url, ID),
+		nil,
+	)
+	if err != nil {
+		return 0, false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Auth-Token", token)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := cl.httpClient.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	cl.debug("Stream response status: %s", resp.Status)
+	if resp.StatusCode != http.StatusOK ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return 0, false, nil
+	}
+
+	written := 0
+	event := ""
+	data := []string{}
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			// blank line dispatches the event
+			if len(data) > 0 && (event == "" || event == "output") {
+				n, err := io.WriteString(w, strings.Join(data, "\n"))
+				written += n
+				if err != nil {
+					return written, true, err
+				}
+			}
+			if event == "end" {
+				return written, true, nil
+			}
+			event = ""
+			data = data[:0]
+		case strings.HasPrefix(line, ":"):
+			// comment / keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			v := strings.TrimPrefix(line, "data:")
+			data = append(data, strings.TrimPrefix(v, " "))
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return written, true, err
+	}
+	return written, true, nil
+}
@@ -0,0 +1,85 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// sse answers a stream request with the events
+func sse(events string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			http.Error(w, "not an event stream request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, events)
+	}
+}
+
+func TestWaitJobStreams(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		stream func(w http.ResponseWriter, r *http.Request)
+		want   string // polled output is "hello\nworld"
+	}{
+		// the polled output isn't repeated once streamed
+		{"streamed", sse(": keep-alive\n\ndata: HELLO\ndata:WORLD\n\nevent: status\ndata: running\n\nevent: end\ndata:\n\n"), "HELLO\nWORLD"},
+		{"not supported", nil, "hello\nworld"},
+		{"not an event stream", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("HELLO")) }, "hello\nworld"},
+		// the rest of the output comes from polling
+		{"dropped", sse("event: output\ndata: HEL\n\n"), "HELlo\nworld"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := startFakeGostint(t, &fakeGostint{
+				statuses: []string{"running", "running", "success"},
+				output:   "hello\nworld",
+				stream:   tc.stream,
+			})
+			ID := g.addJob("")
+			cl, err := NewClient(WithURL(g.URL), WithPollPolicy(FixedPollPolicy(10*time.Millisecond)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			res, err := cl.WaitJob(context.Background(), "token", ID, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != "success" || out.String() != tc.want {
+				t.Errorf("got %s with output %q, want success with %q", res.Status, out.String(), tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Template parameter types
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamBool   = "bool"
+	ParamList   = "list" // comma separated, a []string in the template
+)
+
+// TemplateParam declares a parameter of a JobTemplate
+type TemplateParam struct {
+	Name        string        `json:"-"`
+	Type        string        `json:"type"` // defaults to string
+	Description string        `json:"description"`
+	Required    bool          `json:"required"`
+	Default     interface{}   `json:"default"`
+	Allowed     []interface{} `json:"allowed"`
+}
+
+// JobTemplate is a job, in JSON or YAML, written as a text/template over its
+// declared parameters.  The values its actions insert are data, placed within
+// the strings of the parsed job, so they can't change its structure, e.g.
+//
+//	description: Run an ansible playbook
+//	parameters:
+//	  playbook:
+//	    required: true
+//	  env:
+//	    allowed: [dev, prod]
+//	    default: dev
+//	job: |
+//	  qname: play
+//	  container_image: goethite/gostint-ansible:2.7.5
+//	  run: ["-i", "hosts-{{ .env }}", "{{ .playbook }}"]
+type JobTemplate struct {
+	Name        string          `json:"-"`
+	File        string          `json:"-"`
+	Description string          `json:"description"`
+	Params      []TemplateParam `json:"-"` // sorted by name
+	Job         string          `json:"job"`
+}
+
+// DefaultTemplateDir returns the default folder of job templates,
+// gostint/templates in the user's config folder
+func DefaultTemplateDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gostint", "templates")
+}
+
+// templateExts are the file extensions of templates, in order of preference
+var templateExts = []string{".yaml", ".yml", ".json"}
+
+// LoadTemplate loads the named template from dir
+func LoadTemplate(dir string, name string) (*JobTemplate, error) {
+	for _, ext := range templateExts {
+		file := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(file); err == nil {
+			return loadTemplateFile(file)
+		}
+	}
+	return nil, fmt.Errorf("template '%s' not found in %s", name, dir)
+}
+
+// ListTemplates loads every template in dir, sorted by name
+func ListTemplates(dir string) ([]*JobTemplate, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	tmpls := []*JobTemplate{}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		if e.IsDir() || seen[name] || !isTemplateFile(e.Name()) {
+			continue
+		}
+		seen[name] = true
+		t, err := LoadTemplate(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		tmpls = append(tmpls, t)
+	}
+	sort.Slice(tmpls, func(i, j int) bool { return tmpls[i].Name < tmpls[j].Name })
+	return tmpls, nil
+}
+
+func isTemplateFile(name string) bool {
+	for _, ext := range templateExts {
+		if strings.ToLower(filepath.Ext(name)) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func loadTemplateFile(file string) (*JobTemplate, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		JobTemplate
+		Parameters map[string]TemplateParam `json:"parameters"`
+	}
+	if err = unmarshalYAML(file, b, &raw); err != nil {
+		return nil, err
+	}
+	t := raw.JobTemplate
+	t.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	t.File = file
+	if t.Job == "" {
+		return nil, fmt.Errorf("%s: template has no job", file)
+	}
+	for name, p := range raw.Parameters {
+		p.Name = name
+		if p.Type == "" {
+			p.Type = ParamString
+		}
+		switch p.Type {
+		case ParamString, ParamInt, ParamBool, ParamList:
+		default:
+			return nil, fmt.Errorf("%s: parameter %s: unknown type '%s', must be one of string, int, bool or list", file, name, p.Type)
+		}
+		t.Params = append(t.Params, p)
+	}
+	sort.Slice(t.Params, func(i, j int) bool { return t.Params[i].Name < t.Params[j].Name })
+	if _, err = template.New(t.Name).Funcs(templateFuncs).Parse(t.Job); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return &t, nil
+}
+
+// convert returns a parameter value as its declared type
+func (p TemplateParam) convert(s string) (interface{}, error) {
+	switch p.Type {
+	case ParamInt:
+		return strconv.Atoi(s)
+	case ParamBool:
+		return strconv.ParseBool(s)
+	case ParamList:
+		if s == "" {
+			return []string{}, nil
+		}
+		return strings.Split(s, ","), nil
+	}
+	return s, nil
+}
+
+// allows returns true if s is one of the parameter's allowed values, or for
+// a list if each of its values is
+func (p TemplateParam) allows(s string) bool {
+	if len(p.Allowed) == 0 {
+		return true
+	}
+	values := []string{s}
+	if p.Type == ParamList {
+		values = strings.Split(s, ",")
+	}
+	for _, v := range values {
+		ok := false
+		for _, a := range p.Allowed {
+			if paramString(a) == v {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// paramString returns a declared value as it would be given on the command
+// line
+func paramString(v interface{}) string {
+	switch t := v.(type) {
+	case float64: // yaml numbers, avoiding exponents
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case []interface{}:
+		parts := make([]string, len(t))
+		for i, e := range t {
+			parts[i] = paramString(e)
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// Render expands the template with the parameter values given, checking they
+// are declared, of the right type and allowed, and that required parameters
+// are given.  Undeclared and missing parameters are reported together.
+//
+// The job is returned as YAML, with each value an action inserted placed
+// within the string it was inserted into, see placeValues.  Ints, bools and
+// the output of the json function are inserted as they are.  ${ is escaped
+// as $${ in the values inserted, so only the template's own ${VAR}
+// references are interpolated when the job is loaded.
+func (t *JobTemplate) Render(params map[string]string) (string, error) {
+	declared := map[string]bool{}
+	names := []string{}
+	for _, p := range t.Params {
+		declared[p.Name] = true
+		names = append(names, p.Name)
+	}
+	probs := []string{}
+	for k := range params {
+		if !declared[k] {
+			probs = append(probs, fmt.Sprintf("unknown parameter '%s'", k))
+		}
+	}
+	sort.Strings(probs)
+
+	data := map[string]interface{}{}
+	for _, p := range t.Params {
+		s, ok := params[p.Name]
+		switch {
+		case !ok && p.Required:
+			probs = append(probs, fmt.Sprintf("parameter '%s' is required", p.Name))
+			continue
+		case !ok && p.Default != nil:
+			s = paramString(p.Default)
+		case !ok:
+			data[p.Name] = zeroParam(p.Type)
+			continue
+		}
+		if !p.allows(s) {
+			probs = append(probs, fmt.Sprintf("parameter '%s' value '%s' is not one of %v", p.Name, s, p.Allowed))
+			continue
+		}
+		v, err := p.convert(s)
+		if err != nil {
+			probs = append(probs, fmt.Sprintf("parameter '%s' value '%s' is not a valid %s", p.Name, s, p.Type))
+			continue
+		}
+		data[p.Name] = v
+	}
+	if len(probs) > 0 {
+		return "", fmt.Errorf("template %s: %s (parameters: %s)", t.Name, strings.Join(probs, "; "), strings.Join(names, ", "))
+	}
+
+	values := []string{}
+	funcs := template.FuncMap{
+		"json": func(v interface{}) (jsonText, error) {
+			s, err := toJSON(v)
+			return jsonText(s), err
+		},
+		valueFunc: func(v interface{}) string {
+			switch v := v.(type) {
+			case jsonText:
+				return escapeVarRefs(string(v))
+			case bool, int:
+				return fmt.Sprint(v)
+			}
+			values = append(values, fmt.Sprint(v))
+			return fmt.Sprintf("%s%d%s", valueMarkStart, len(values)-1, valueMarkEnd)
+		},
+	}
+	tmpl, err := template.New(t.Name).Funcs(templateFuncs).Funcs(funcs).Option("missingkey=error").Parse(t.Job)
+	if err != nil {
+		return "", err
+	}
+	for _, tt := range tmpl.Templates() {
+		if tt.Tree != nil {
+			markValues(tt.Tree.Root)
+		}
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("%s: %s", t.File, err)
+	}
+	return placeValues("template "+t.Name, buf.String(), values)
+}
+
+// valueFunc is the template function added to the end of each action that
+// outputs a value, to mark where it is inserted
+const valueFunc = "_value"
+
+// valueMarks delimit the index of a value inserted by an action, in the job
+// rendered before the values are placed
+const (
+	valueMarkStart = "\uE000"
+	valueMarkEnd   = "\uE001"
+)
+
+var valueMarkRe = regexp.MustCompile(valueMarkStart + "([0-9]+)" + valueMarkEnd)
+
+// jsonText is the output of the json function in a job template, which is
+// inserted as it is
+type jsonText string
+
+// markValues adds valueFunc to the end of each action under n that outputs
+// a value, as html/template adds its escapers
+func markValues(n parse.Node) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			markValues(c)
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) == 0 {
+			n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Pos:      n.Pos,
+				Args:     []parse.Node{parse.NewIdentifier(valueFunc).SetPos(n.Pos)},
+			})
+		}
+	case *parse.IfNode:
+		markValues(n.List)
+		markValues(n.ElseList)
+	case *parse.RangeNode:
+		markValues(n.List)
+		markValues(n.ElseList)
+	case *parse.WithNode:
+		markValues(n.List)
+		markValues(n.ElseList)
+	}
+}
+
+// placeValues parses a job rendered with marks in place of the values its
+// actions inserted, and places each value within the string it was inserted
+// into, so it can't change the job's structure, returning the job as YAML
+func placeValues(name string, text string, values []string) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return "", fmt.Errorf("%s", syntaxProblem(name, err))
+	}
+	if len(doc.Content) == 0 {
+		return text, nil
+	}
+	placeNodeValues(&doc, values)
+	// a JSON job is written out as YAML too
+	doc.Content[0].Style &^= yaml.FlowStyle
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return "", fmt.Errorf("%s: %s", name, err)
+	}
+	if err := enc.Close(); err != nil {
+		return "", fmt.Errorf("%s: %s", name, err)
+	}
+	return buf.String(), nil
+}
+
+// placeNodeValues replaces the marks in the scalars under n with the values
+// they mark, making the scalars strings
+func placeNodeValues(n *yaml.Node, values []string) {
+	if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, valueMarkStart) {
+		n.Value = valueMarkRe.ReplaceAllStringFunc(n.Value, func(m string) string {
+			i, _ := strconv.Atoi(m[len(valueMarkStart) : len(m)-len(valueMarkEnd)])
+			return escapeVarRefs(values[i])
+		})
+		n.Tag = "!!str"
+	}
+	for _, c := range n.Content {
+		placeNodeValues(c, values)
+	}
+}
+
+// escapeVarRefs escapes the ${VAR} references in s, see Interpolate
+func escapeVarRefs(s string) string {
+	return strings.Replace(s, "${", "$${", -1)
+}
+
+func zeroParam(typ string) interface{} {
+	switch typ {
+	case ParamInt:
+		return 0
+	case ParamBool:
+		return false
+	case ParamList:
+		return []string{}
+	}
+	return ""
+}
+
+// toJSON is the template function json, for values needing json encoding
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,109 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRenderJSONEscapesParams(t *testing.T) {
+	tmpl := &JobTemplate{
+		Name:   "play",
+		Params: []TemplateParam{{Name: "playbook", Type: ParamString, Required: true}},
+		Job:    `{"container_image": "alpine", "run": ["-i", "hosts", {{ json .playbook }}]}`,
+	}
+	job, err := tmpl.Render(map[string]string{"playbook": `a", "--extra`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := JobSpec{}
+	js, err := JobJSON("template play", job, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal([]byte(js), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"-i", "hosts", `a", "--extra`}; !reflect.DeepEqual(spec.Run, want) {
+		t.Errorf("got run %q, want %q", spec.Run, want)
+	}
+}
+
+func TestRenderPlacesParams(t *testing.T) {
+	tmpl := &JobTemplate{
+		Name: "play",
+		Params: []TemplateParam{
+			{Name: "playbook", Type: ParamString},
+			{Name: "env", Type: ParamString},
+			{Name: "retries", Type: ParamInt},
+			{Name: "cont", Type: ParamBool},
+			{Name: "tags", Type: ParamList},
+		},
+		Job: `qname: play
+run:
+  - -i
+  - hosts-{{ .env }}
+  - "{{ .playbook }}"
+  - '{{ .playbook }}'
+  - {{ .playbook }}{{ range .tags }}
+  - --tags={{ . }}{{ end }}
+  - {{ json .tags }}
+env_vars: ["HOME=${HOME}", "PLAYBOOK={{ .playbook }}"]
+cont_on_warnings: {{ .cont }}
+retries: {{ .retries }}
+`,
+	}
+	playbook := "a\", \"--extra\nqname: evil # ${HOME}"
+	job, err := tmpl.Render(map[string]string{
+		"playbook": playbook, "env": "prod", "retries": "3", "cont": "true", "tags": "x,${HOME}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := JobJSON("template play", job, map[string]string{"HOME": "/home/me"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	if err = json.Unmarshal([]byte(js), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"qname": "play",
+		"run": []interface{}{
+			"-i", "hosts-prod", playbook, playbook, playbook, "--tags=x", "--tags=${HOME}", []interface{}{"x", "${HOME}"},
+		},
+		"env_vars":         []interface{}{"HOME=/home/me", "PLAYBOOK=" + playbook},
+		"cont_on_warnings": true,
+		"retries":          float64(3),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TLSConfig holds the trust settings for the connection to the gostint API.
+// Server certificates are verified unless Insecure is set.
+type TLSConfig struct {
+	CACert     string   // PEM bundle of CAs to trust instead of the system roots
+	CAPath     string   // Folder of PEM CA certificates to trust
+	ServerName string   // Override the name expected in the server certificate
+	ClientCert string   // PEM client certificate for mutual TLS
+	ClientKey  string   // PEM private key for ClientCert
+	PinSHA256  []string // base64 sha256 hashes of acceptable server SPKIs
+	Insecure   bool     // Skip server certificate verification
+}
+
+// WithTLS sets the trust settings for the connection to the gostint API
+func WithTLS(t TLSConfig) Option {
+	return func(cl *Client) error {
+		cl.tls = t
+		return nil
+	}
+}
+
+// build returns the crypto/tls configuration for these settings
+func (t TLSConfig) build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.Insecure,
+	}
+
+	if t.CACert != "" || t.CAPath != "" {
+		pool := x509.NewCertPool()
+		if t.CACert != "" {
+			if err := appendCAFile(pool, t.CACert); err != nil {
+				return nil, err
+			}
+		}
+		if t.CAPath != "" {
+			if err := appendCAPath(pool, t.CAPath); err != nil {
+				return nil, err
+			}
+		}
+		cfg.RootCAs = pool
+	}
+
+	if t.ClientCert != "" || t.ClientKey != "" {
+		if t.ClientCert == "" || t.ClientKey == "" {
+			return nil, fmt.Errorf("tls client certificate and key must both be specified")
+		}
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading tls client certificate: %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(t.PinSHA256) > 0 {
+		pins := map[string]bool{}
+		for _, p := range t.PinSHA256 {
+			p = strings.TrimPrefix(strings.TrimSpace(p), "sha256//")
+			b, err := base64.StdEncoding.DecodeString(p)
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("invalid sha256 pin '%s', must be a base64 encoded sha256 hash", p)
+			}
+			pins[string(b)] = true
+		}
+		insecure := t.Insecure
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			// only the leaf, whose key the server has proven it holds, or the
+			// verified chain may match a pin, as any server could send the
+			// pinned certificate alongside its own
+			if insecure {
+				if len(rawCerts) == 0 {
+					return fmt.Errorf("gostint server sent no certificate")
+				}
+				cert, err := x509.ParseCertificate(rawCerts[0])
+				if err != nil {
+					return err
+				}
+				if pinned(pins, cert) {
+					return nil
+				}
+			}
+			for _, chain := range verifiedChains {
+				for _, cert := range chain {
+					if pinned(pins, cert) {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("gostint server certificate does not match any pinned public key")
+		}
+	}
+
+	return cfg, nil
+}
+
+// pinned returns true if the certificate's public key matches one of the pins
+func pinned(pins map[string]bool, cert *x509.Certificate) bool {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pins[string(sum[:])]
+}
+
+func appendCAFile(pool *x509.CertPool, file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return fmt.Errorf("no PEM certificates found in %s", file)
+	}
+	return nil
+}
+
+func appendCAPath(pool *x509.CertPool, dir string) error {
+	found := false
+	err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if pool.AppendCertsFromPEM(b) {
+			found = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no PEM certificates found in %s", dir)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its key for TLS tests
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert returns a certificate for 127.0.0.1 issued by parent, or self
+// signed if parent is nil
+func newTestCert(t *testing.T, name string, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	issuer, issuerKey := tmpl, key
+	if parent != nil {
+		issuer, issuerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// writePEM writes a certificate, or its key, as a PEM file in dir
+func (c *testCert) writePEM(t *testing.T, dir string, name string, key bool) string {
+	t.Helper()
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}
+	if key {
+		b, err := x509.MarshalECPrivateKey(c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
+	}
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// pin returns the sha256 pin of a certificate's public key
+func pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// writeCACert writes the certificate of a test server as a PEM file
+func writeCACert(t *testing.T, dir string, g *fakeGostint) string {
+	return (&testCert{cert: g.Certificate()}).writePEM(t, dir, "ca.pem", false)
+}
+
+// getTLSJob gets a job from g over TLS configured by tc
+func getTLSJob(t *testing.T, g *fakeGostint, tc TLSConfig) error {
+	t.Helper()
+	cl, err := NewClient(WithURL(g.URL), WithTLS(tc), WithRetryPolicy(RetryPolicy{Multiplier: 1}))
+	if err != nil {
+		return err
+	}
+	_, err = cl.GetJob(context.Background(), "token", g.addJob(""))
+	return err
+}
+
+func TestTLSTrust(t *testing.T) {
+	g := startFakeGostint(t, &fakeGostint{tls: &tls.Config{}})
+	dir := t.TempDir()
+	caFile := writeCACert(t, dir, g)
+	good := pin(g.Certificate())
+	other := newTestCert(t, "other", false, nil)
+	bad := pin(other.cert)
+
+	for _, tc := range []struct {
+		name string
+		tls  TLSConfig
+		err  string // in the error, if any
+	}{
+		{"unknown ca", TLSConfig{}, "certificate signed by unknown authority"},
+		{"ca bundle", TLSConfig{CACert: caFile}, ""},
+		{"ca path", TLSConfig{CAPath: dir}, ""},
+		{"other ca", TLSConfig{CACert: other.writePEM(t, t.TempDir(), "other.pem", false)}, "certificate signed by unknown authority"},
+		{"wrong server name", TLSConfig{CACert: caFile, ServerName: "gostint.example"}, "certificate is valid for"},
+		{"insecure", TLSConfig{Insecure: true}, ""},
+		{"pin", TLSConfig{CACert: caFile, PinSHA256: []string{good}}, ""},
+		{"pin prefixed", TLSConfig{CACert: caFile, PinSHA256: []string{"sha256//" + good}}, ""},
+		{"pin mismatch", TLSConfig{CACert: caFile, PinSHA256: []string{bad}}, "does not match any pinned public key"},
+		{"pin rotation", TLSConfig{CACert: caFile, PinSHA256: []string{bad, good}}, ""},
+		{"pin insecure", TLSConfig{Insecure: true, PinSHA256: []string{good}}, ""},
+		{"pin mismatch insecure", TLSConfig{Insecure: true, PinSHA256: []string{bad}}, "does not match any pinned public key"},
+		{"pin unknown ca", TLSConfig{PinSHA256: []string{good}}, "certificate signed by unknown authority"},
+		{"invalid pin", TLSConfig{PinSHA256: []string{"not-a-pin"}}, "invalid sha256 pin"},
+		{"missing ca bundle", TLSConfig{CACert: filepath.Join(dir, "missing.pem")}, "no such file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := getTLSJob(t, g, tc.tls)
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("got error %v", err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Errorf("got error %v, want one containing %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestTLSPinOnlyMatchesLeafWhenInsecure(t *testing.T) {
+	// a server can send any certificate, e.g. a pinned CA's, after its own
+	ca := newTestCert(t, "pinned ca", true, nil)
+	leaf := newTestCert(t, "gostint", false, nil)
+	g := startFakeGostint(t, &fakeGostint{tls: &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{leaf.cert.Raw, ca.cert.Raw},
+		PrivateKey:  leaf.key,
+	}}}})
+
+	err := getTLSJob(t, g, TLSConfig{Insecure: true, PinSHA256: []string{pin(ca.cert)}})
+	if err == nil || !strings.Contains(err.Error(), "does not match any pinned public key") {
+		t.Errorf("got error %v, want the pin not to match", err)
+	}
+	if err = getTLSJob(t, g, TLSConfig{Insecure: true, PinSHA256: []string{pin(leaf.cert)}}); err != nil {
+		t.Errorf("got error %v pinning the leaf", err)
+	}
+}
+
+func TestTLSPinMatchesVerifiedChain(t *testing.T) {
+	ca := newTestCert(t, "pinned ca", true, nil)
+	leaf := newTestCert(t, "gostint", false, ca)
+	g := startFakeGostint(t, &fakeGostint{tls: &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{leaf.cert.Raw},
+		PrivateKey:  leaf.key,
+	}}}})
+	caFile := ca.writePEM(t, t.TempDir(), "ca.pem", false)
+
+	if err := getTLSJob(t, g, TLSConfig{CACert: caFile, PinSHA256: []string{pin(ca.cert)}}); err != nil {
+		t.Errorf("got error %v pinning the ca", err)
+	}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	clientCA := newTestCert(t, "client ca", true, nil)
+	client := newTestCert(t, "client", false, clientCA)
+	stranger := newTestCert(t, "stranger", false, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCA.cert)
+	g := startFakeGostint(t, &fakeGostint{tls: &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}})
+	dir := t.TempDir()
+	caFile := writeCACert(t, dir, g)
+	certFile := client.writePEM(t, dir, "client.pem", false)
+	keyFile := client.writePEM(t, dir, "client-key.pem", true)
+	strangerCert := stranger.writePEM(t, dir, "stranger.pem", false)
+	strangerKey := stranger.writePEM(t, dir, "stranger-key.pem", true)
+
+	for _, tc := range []struct {
+		name string
+		tls  TLSConfig
+		err  string
+	}{
+		{"client certificate", TLSConfig{CACert: caFile, ClientCert: certFile, ClientKey: keyFile}, ""},
+		{"no client certificate", TLSConfig{CACert: caFile}, "certificate required"},
+		{"unknown client certificate", TLSConfig{CACert: caFile, ClientCert: strangerCert, ClientKey: strangerKey}, "certificate required"},
+		{"no client key", TLSConfig{CACert: caFile, ClientCert: certFile}, "must both be specified"},
+		{"mismatched client key", TLSConfig{CACert: caFile, ClientCert: certFile, ClientKey: strangerKey}, "loading tls client certificate"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := getTLSJob(t, g, tc.tls)
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("got error %v", err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Errorf("got error %v, want one containing %q", err, tc.err)
+			}
+		})
+	}
+}
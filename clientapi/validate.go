@@ -0,0 +1,434 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is something wrong with a job, located by line and column in the
+// job's source where known
+type Problem struct {
+	File    string
+	Line    int // 0 if not known
+	Column  int // 0 if not known
+	Message string
+	Warning bool // the job can still be submitted
+}
+
+func (p Problem) String() string {
+	loc := p.File
+	if p.Line > 0 {
+		loc += ":" + strconv.Itoa(p.Line)
+		if p.Column > 0 {
+			loc += ":" + strconv.Itoa(p.Column)
+		}
+	}
+	if p.Warning {
+		return loc + ": warning: " + p.Message
+	}
+	return loc + ": " + p.Message
+}
+
+// ValidationError is returned for an invalid job, listing all of its
+// problems, warnings included
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid job: " + e.Problems[0].String()
+	}
+	lines := []string{"invalid job:"}
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ProblemsError returns a *ValidationError of problems if any is an error,
+// else nil
+func ProblemsError(problems []Problem) error {
+	for _, p := range problems {
+		if !p.Warning {
+			return &ValidationError{Problems: problems}
+		}
+	}
+	return nil
+}
+
+// ValidateJob checks a job given as JSON or YAML, returning every problem
+// found, in order, located by line and column: syntax errors, fields of the
+// wrong type, values outside the image_pull_policy and secret_file_type enums,
+// env_vars not NAME=value and secret_refs not name@path.field.  Unknown fields
+// that look like misspelt known fields are errors, other unknown fields are
+// warnings, or errors if strict.  name is the job's file name, to report
+// problems against.
+func ValidateJob(name string, text string, strict bool) []Problem {
+	doc, p := parseJob(name, text)
+	if p != nil {
+		return []Problem{*p}
+	}
+	return validateJob(name, doc, strict)
+}
+
+// parseJob parses a job given as JSON or YAML, returning a syntax error as a
+// Problem.  Both are parsed by yaml.v3, JSON being a subset of YAML 1.2, once
+// JSON is checked to report its syntax errors as JSON's.
+func parseJob(name string, text string) (*yaml.Node, *Problem) {
+	if !isYAML(name, text) {
+		if p := jsonSyntaxProblem(name, text); p != nil {
+			return nil, p
+		}
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		p := syntaxProblem(name, err)
+		return nil, &p
+	}
+	return &doc, nil
+}
+
+// validateJob checks a parsed job, see ValidateJob
+func validateJob(name string, doc *yaml.Node, strict bool) []Problem {
+	v := &validator{file: name}
+	if len(doc.Content) == 0 {
+		v.errorf(doc, "job is empty")
+		return v.problems
+	}
+	root := resolveAlias(doc.Content[0])
+	if root.Kind != yaml.MappingNode {
+		v.errorf(root, "job must be an object of fields")
+		return v.problems
+	}
+	seen := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, val := root.Content[i], resolveAlias(root.Content[i+1])
+		if k.Tag == "!!merge" {
+			continue
+		}
+		field := strings.ToLower(k.Value)
+		if first, ok := seen[field]; ok {
+			v.errorf(k, "duplicate field %s, first given at line %d", k.Value, first.Line)
+			continue
+		}
+		seen[field] = k
+		if prop, ok := jobSchema.Properties[field]; ok {
+			v.check(k.Value, prop, val)
+		} else {
+			v.unknown(k, strict)
+		}
+	}
+	return v.problems
+}
+
+// ValidateJobField checks the value of a single job field given apart from a
+// job, e.g. the JSON list of a command line flag, reporting problems against
+// name
+func ValidateJobField(name string, field string, text string) []Problem {
+	v := &validator{file: name}
+	prop, ok := jobSchema.Properties[field]
+	switch {
+	case !ok:
+		v.problems = append(v.problems, Problem{File: name, Message: fmt.Sprintf("unknown field %s", field)})
+	case prop.Type == "string":
+		// taken as given, not parsed
+		v.check(field, prop, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: text})
+	default:
+		if p := jsonSyntaxProblem(name, text); p != nil {
+			return []Problem{*p}
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+			return []Problem{syntaxProblem(name, err)}
+		}
+		if len(doc.Content) == 0 {
+			v.errorf(&doc, "%s is empty", field)
+			break
+		}
+		v.check(field, prop, resolveAlias(doc.Content[0]))
+	}
+	return v.problems
+}
+
+// validator collects the problems found in a job
+type validator struct {
+	file     string
+	problems []Problem
+}
+
+func (v *validator) errorf(n *yaml.Node, format string, a ...interface{}) {
+	v.problems = append(v.problems, Problem{File: v.file, Line: n.Line, Column: n.Column, Message: fmt.Sprintf(format, a...)})
+}
+
+func (v *validator) warnf(n *yaml.Node, format string, a ...interface{}) {
+	v.problems = append(v.problems, Problem{File: v.file, Line: n.Line, Column: n.Column, Message: fmt.Sprintf(format, a...), Warning: true})
+}
+
+// check checks a value against its schema, name being how to refer to it
+func (v *validator) check(name string, s *Schema, n *yaml.Node) {
+	if n.Tag == "!!null" {
+		return
+	}
+	switch s.Type {
+	case "string":
+		if !v.isString(name, n) {
+			return
+		}
+		if p := checkValue(name, s, n.Value); p != "" {
+			v.errorf(n, "%s", p)
+		}
+	case "boolean":
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!bool" {
+			v.errorf(n, "%s must be true or false", name)
+		}
+	case "array":
+		if s.Items == nil {
+			if n.Kind != yaml.SequenceNode {
+				v.errorf(n, "%s must be a list", name)
+			}
+			return
+		}
+		if n.Kind != yaml.SequenceNode {
+			v.errorf(n, "%s must be a list of %ss", name, s.Items.Type)
+			return
+		}
+		for i, item := range n.Content {
+			v.check(fmt.Sprintf("%s[%d]", name, i), s.Items, resolveAlias(item))
+		}
+	case "object":
+		if n.Kind != yaml.MappingNode {
+			v.errorf(n, "%s must be an object", name)
+			return
+		}
+		if more, ok := s.AdditionalProperties.(*Schema); ok {
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				v.check(name+"."+n.Content[i].Value, more, resolveAlias(n.Content[i+1]))
+			}
+		}
+	}
+}
+
+// checkValue returns what is wrong with a string value of the schema s, if
+// anything, name being how to refer to it.  An empty value is taken as unset
+// rather than outside an enum.
+func checkValue(name string, s *Schema, value string) string {
+	if len(s.Enum) > 0 && value != "" && !contains(s.Enum, value) {
+		return fmt.Sprintf("%s '%s' must be %s or %s", name, value,
+			strings.Join(s.Enum[:len(s.Enum)-1], ", "), s.Enum[len(s.Enum)-1])
+	}
+	if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(value) {
+		return fmt.Sprintf("%s '%s' %s", name, value, s.PatternErrorMessage)
+	}
+	return ""
+}
+
+// isString returns true if n is a string, else reports it
+func (v *validator) isString(name string, n *yaml.Node) bool {
+	switch {
+	case n.Kind == yaml.ScalarNode && n.Tag == "!!str":
+		return true
+	case n.Kind == yaml.ScalarNode:
+		v.errorf(n, "%s must be a string, quote %s to make it one", name, n.Value)
+	default:
+		v.errorf(n, "%s must be a string", name)
+	}
+	return false
+}
+
+// unknown reports a field unknown to this client
+func (v *validator) unknown(k *yaml.Node, strict bool) {
+	switch near := nearestJobField(k.Value); {
+	case near != "":
+		v.errorf(k, "unknown field %s, did you mean %s?", k.Value, near)
+	case strict:
+		v.errorf(k, "unknown field %s", k.Value)
+	default:
+		v.warnf(k, "field %s is unknown to this client, passing it to gostint unchanged", k.Value)
+	}
+}
+
+// nearestJobField returns the known field that an unknown field is likely a
+// misspelling of, if any: the same but for case, '_' or '-', else within an
+// edit or two
+func nearestJobField(name string) string {
+	squash := strings.NewReplacer("_", "", "-", "")
+	lower := strings.ToLower(name)
+	fields := []string{}
+	for f := range knownJobFields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	best, bestDist := "", 0
+	for _, f := range fields {
+		if squash.Replace(lower) == squash.Replace(f) {
+			return f
+		}
+		limit := 1
+		if len(f) >= 6 {
+			limit = 2
+		}
+		if d := editDistance(lower, f); d <= limit && (best == "" || d < bestDist) {
+			best, bestDist = f, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// resolveAlias returns the node a YAML alias refers to
+func resolveAlias(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+// jsonSyntaxProblem returns the syntax error in text as JSON, if any, located
+// by line and column
+func jsonSyntaxProblem(name string, text string) *Problem {
+	var v interface{}
+	err := json.Unmarshal([]byte(text), &v)
+	if err == nil {
+		return nil
+	}
+	p := Problem{File: name, Message: err.Error()}
+	if se, ok := err.(*json.SyntaxError); ok {
+		before := text[:se.Offset]
+		p.Line = 1 + strings.Count(before, "\n")
+		p.Column = len(before) - strings.LastIndex(before, "\n") - 1
+	}
+	return &p
+}
+
+var yamlErrorRe = regexp.MustCompile(`^yaml: (?:line (\d+): )?(.*)$`)
+
+// yamlParserProblems are the problems reported by the yaml.v3 parser, which
+// numbers their lines from 0
+var yamlParserProblems = map[string]bool{
+	"did not find expected <stream-start>":   true,
+	"did not find expected <document start>": true,
+	"did not find expected node content":     true,
+	"did not find expected key":              true,
+	"did not find expected '-' indicator":    true,
+	"did not find expected ',' or ']'":       true,
+	"did not find expected ',' or '}'":       true,
+	"found duplicate %YAML directive":        true,
+	"found duplicate %TAG directive":         true,
+	"found incompatible YAML document":       true,
+	"found undefined tag handle":             true,
+}
+
+// yamlScannerProblems are the problems reported by the yaml.v3 scanner,
+// which numbers their lines from 1
+var yamlScannerProblems = map[string]bool{
+	"block sequence entries are not allowed in this context":       true,
+	"could not find expected ':'":                                  true,
+	"could not find expected directive name":                       true,
+	"did not find URI escaped octet":                               true,
+	"did not find expected '!'":                                    true,
+	"did not find expected alphabetic or numeric character":        true,
+	"did not find expected comment or line break":                  true,
+	"did not find expected digit or '.' character":                 true,
+	"did not find expected hexdecimal number":                      true,
+	"did not find expected tag URI":                                true,
+	"did not find expected version number":                         true,
+	"did not find expected whitespace or line break":               true,
+	"did not find expected whitespace":                             true,
+	"did not find the expected '>'":                                true,
+	"found a tab character that violates indentation":              true,
+	"found a tab character where an indentation space is expected": true,
+	"found an incorrect leading UTF-8 octet":                       true,
+	"found an incorrect trailing UTF-8 octet":                      true,
+	"found an indentation indicator equal to 0":                    true,
+	"found character that cannot start any token":                  true,
+	"found extremely long version number":                          true,
+	"found invalid Unicode character escape code":                  true,
+	"found unexpected document indicator":                          true,
+	"found unexpected end of stream":                               true,
+	"found unexpected non-alphabetical character":                  true,
+	"found unknown directive name":                                 true,
+	"found unknown escape character":                               true,
+	"mapping keys are not allowed in this context":                 true,
+	"mapping values are not allowed in this context":               true,
+}
+
+// syntaxProblem returns a parse error as a Problem, located by line where
+// the parser says.  yaml.v3 leaves line 1 out of parser and scanner errors,
+// and locates neither its other errors, e.g. of unknown anchors.
+func syntaxProblem(name string, err error) Problem {
+	m := yamlErrorRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return Problem{File: name, Message: err.Error()}
+	}
+	p := Problem{File: name, Message: m[2]}
+	switch line, _ := strconv.Atoi(m[1]); {
+	case yamlParserProblems[p.Message]:
+		p.Line = line + 1
+	case yamlScannerProblems[p.Message] && line == 0:
+		p.Line = 1
+	default:
+		p.Line = line
+	}
+	return p
+}
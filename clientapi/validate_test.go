@@ -0,0 +1,127 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clientapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func problemStrings(problems []Problem) []string {
+	strs := []string{}
+	for _, p := range problems {
+		strs = append(strs, p.String())
+	}
+	return strs
+}
+
+func TestValidateJobLocations(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		file   string
+		text   string
+		strict bool
+		want   []string
+	}{
+		{"valid", "play.yaml", "qname: play\ncontainer_image: alpine\nrun: [ls]\n", false, []string{}},
+		{"yaml", "play.yaml", `qname: play
+containerimage: alpine
+image_pull_policy: Sometimes
+secret_file_type: xml
+run:
+  - ls
+  - 42
+env_vars: [A=1, NOEQUALS]
+secret_refs:
+  - bad
+cont_on_warnings: maybe
+future: 1
+qname: again
+`, false, []string{
+			"play.yaml:2:1: unknown field containerimage, did you mean container_image?",
+			"play.yaml:3:20: image_pull_policy 'Sometimes' must be IfNotPresent or Always",
+			"play.yaml:4:19: secret_file_type 'xml' must be yaml or json",
+			"play.yaml:7:5: run[1] must be a string, quote 42 to make it one",
+			"play.yaml:8:17: env_vars[1] 'NOEQUALS' must be NAME=value",
+			"play.yaml:10:5: secret_refs[0] 'bad' must be name@path.field",
+			"play.yaml:11:19: cont_on_warnings must be true or false",
+			"play.yaml:12:1: warning: field future is unknown to this client, passing it to gostint unchanged",
+			"play.yaml:13:1: duplicate field qname, first given at line 1",
+		}},
+		{"json strict", "play.json", "{\"qname\": \"q\",\n \"env_vars\": [\"A=1\", \"B\"], \"future\": 1}", true, []string{
+			"play.json:2:22: env_vars[1] 'B' must be NAME=value",
+			"play.json:2:28: unknown field future",
+		}},
+		{"json syntax", "play.json", "{\n  \"qname\": \"play\",\n  \"run\": [\"ls\" 42]\n}", false, []string{
+			"play.json:3:16: invalid character '4' after array element",
+		}},
+		{"yaml scanner syntax", "play.yaml", "qname: play\nrun: a: b\n", false, []string{
+			"play.yaml:2: mapping values are not allowed in this context",
+		}},
+		{"yaml parser syntax", "play.yaml", "qname: play\n\nrun: [a\n", false, []string{
+			"play.yaml:3: did not find expected ',' or ']'",
+		}},
+		{"yaml scanner syntax did not find", "play.yaml", "qname: play\n\nrun: &\n", false, []string{
+			"play.yaml:3: did not find expected alphabetic or numeric character",
+		}},
+		{"yaml scanner syntax on line 1", "play.yaml", "run: &\n", false, []string{
+			"play.yaml:1: did not find expected alphabetic or numeric character",
+		}},
+		{"yaml parser syntax on line 2", "play.yaml", "qname: play\nrun: [a\nimage: x\n", false, []string{
+			"play.yaml:2: did not find expected ',' or ']'",
+		}},
+		{"yaml parser syntax in a list", "play.yaml", "- a\nqname: play\n", false, []string{
+			"play.yaml:2: did not find expected '-' indicator",
+		}},
+		{"yaml unknown anchor", "play.yaml", "qname: *q\n", false, []string{
+			"play.yaml: unknown anchor 'q' referenced",
+		}},
+		{"list", "play.yaml", "- a\n", false, []string{"play.yaml:1:1: job must be an object of fields"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := problemStrings(ValidateJob(tc.file, tc.text, tc.strict))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got\n  %q\nwant\n  %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateJobField(t *testing.T) {
+	got := problemStrings(ValidateJobField("-env-vars", "env_vars", `["A=1", "B"]`))
+	if want := []string{"-env-vars:1:9: env_vars[1] 'B' must be NAME=value"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLoadJobLocatesInterpolatedProblems(t *testing.T) {
+	_, _, err := LoadJob("play.yaml", "qname: ${Q}\nimage_pull_policy: ${POLICY:-Sometimes}\n", map[string]string{"Q": "play"}, true)
+	want := "invalid job: play.yaml:2:20: image_pull_policy 'Sometimes' must be IfNotPresent or Always"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
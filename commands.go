@@ -0,0 +1,869 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goethite/gostint-client/clientapi"
+
+	"github.com/fatih/color"
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "[flags]", "Submit a job and (by default) wait for it to complete", cmdRun},
+		{"status", "<job-id> [flags]", "Show the state of a job", cmdStatus},
+		{"wait", "<job-id> [flags]", "Wait for a job to complete, streaming its output", cmdWait},
+		{"output", "<job-id> [flags]", "Print the output of a job", cmdOutput},
+		{"kill", "<job-id> [flags]", "Kill a queued or running job", cmdKill},
+		{"list", "[flags]", "List jobs, optionally by queue and status", cmdList},
+		{"batch", "<manifest> [flags]", "Submit the jobs in a YAML/JSON manifest, in parallel", cmdBatch},
+		{"pipeline", "<pipeline> [flags]", "Run a YAML/JSON pipeline of dependent job steps", cmdPipeline},
+		{"validate", "<job-file>... [flags]", "Check job files, reporting every problem found with its line and column", cmdValidate},
+		{"doctor", "[flags]", "Check the Vault permissions and connectivity needed to run jobs", cmdDoctor},
+		{"policy", "generate [flags]", "Generate the minimal Vault policy HCL for the client, or for GoStint's role", cmdPolicy},
+		{"schema", "", "Print the JSON Schema of job files, for editors to validate them with", cmdSchema},
+		{"context", "use|list|show [name]", "Switch between, list or show the contexts of settings in the config file", cmdContext},
+		{"templates", "list|show [name] [flags]", "List the job templates, or show a template's parameters", cmdTemplates},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n  %s <command> [arguments]\n\nCommands:\n", os.Args[0])
+	tw := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(os.Stderr, "\nWith no command, flags alone are taken as 'run'.  Use '<command> -h' for a command's flags.\n")
+}
+
+// newFlagSet returns a flag set for the command
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s %s:\n", name, args)
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nFlags may also be set by env vars, e.g. %s for -vault-url.\n", envName("vault-url"))
+	}
+	return fs
+}
+
+// parseArgs parses flags that may appear before or after positional
+// arguments, returning the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	pos := []string{}
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return pos
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// jobCommand sets up the common flags of a command operating on an existing
+// job, parses them and returns the job id
+func jobCommand(name string, args []string, c *clientapi.APIRequest, o *clientapi.ClientOptions, fs *flag.FlagSet) (string, *bool) {
+	connFlags(fs, c, o)
+	deb := fs.Bool("debug", false, "Enable debugging")
+	pos := parseArgs(fs, args)
+	enableDebug = *deb
+	chkError(applyDefaults(fs))
+	if len(pos) != 1 {
+		fs.Usage()
+		chkError(fmt.Errorf("%s requires a single job id", name))
+	}
+	chkError(validateConn(*c, *o))
+	chkError(resolveConn(c))
+	return pos[0], deb
+}
+
+// withToken calls fn with a minimal gostint api token derived from the vault
+// authentication, revoking the token afterwards
+func withToken(ctx context.Context, cl *clientapi.Client, fn func(token string) error) error {
+	token, revoke, err := cl.APIToken(ctx)
+	if err != nil {
+		return err
+	}
+	defer revoke()
+	return fn(token)
+}
+
+// checkJobJSON checks the fields of a job given as JSON, see checkJobFields
+func checkJobJSON(name string, js string, strict bool) {
+	if js == "" {
+		return
+	}
+	spec := map[string]interface{}{}
+	chkError(json.Unmarshal([]byte(js), &spec))
+	delete(spec, "matrix")
+	checkJobFields(name, clientapi.UnknownJobFields(spec), strict)
+}
+
+// checkJobFields warns of fields of a job unknown to this client, which are
+// passed to gostint unchanged, or if strict rejects them
+func checkJobFields(name string, unknown []string, strict bool) {
+	if len(unknown) == 0 {
+		return
+	}
+	what := "job"
+	if name != "" {
+		what = "job " + name
+	}
+	if strict {
+		chkError(fmt.Errorf("%s has fields unknown to this client: %s", what, strings.Join(unknown, ", ")))
+	}
+	warn("Warning: %s has fields unknown to this client, passing them to gostint unchanged: %s", what, strings.Join(unknown, ", "))
+}
+
+// exitCode returns the exit code for a completed job
+func exitCode(res *clientapi.GetResponse) int {
+	if exitCodes == exitCodesClient {
+		if res.Status != "success" || res.ReturnCode != 0 {
+			return exitError
+		}
+		return 0
+	}
+	if res.Status != "success" && res.ReturnCode == 0 {
+		// force non-zero rc - this can happen if executable not found in the container
+		return 1
+	}
+	return res.ReturnCode
+}
+
+// exitWithReport writes a job's result in a machine readable format and exits
+// with its return code, or as for err if the job could not be run or waited on
+func exitWithReport(format string, jr jobReport, err error) {
+	chkError(writeJobReport(os.Stdout, format, jr))
+	chkError(err)
+	if jr.Job == nil || jr.Job.Status == "queued" || jr.Job.Status == "running" {
+		os.Exit(0)
+	}
+	os.Exit(exitCode(jr.Job))
+}
+
+// exitWithCombinedReport writes the results of several jobs in a machine
+// readable format, exiting non-zero if any did not pass
+func exitWithCombinedReport(format string, rep report) {
+	chkError(writeReport(os.Stdout, format, rep))
+	if rep.Failed+rep.Errors > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// exitWithResult prints a completed job's result and exits with its return
+// code
+func exitWithResult(res *clientapi.GetResponse, streamed bool) {
+	debug("Final job state: %v", res)
+	if res.Status == "success" {
+		if !streamed {
+			fmt.Print(res.Output)
+		}
+	} else {
+		if streamed {
+			color.HiRed("[%s]", res.Status)
+		} else {
+			color.HiRed("[%s] %s", res.Status, res.Output)
+		}
+	}
+	os.Exit(exitCode(res))
+}
+
+// waitContext returns the context to wait on a job with, cancelled by the
+// timeout or on interrupt
+func waitContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		parent := cancel
+		cancel = func() {
+			cancelTimeout()
+			parent()
+		}
+	}
+	handleSignals(cancel)
+	return ctx, cancel
+}
+
+func cmdRun(args []string) {
+	c := clientapi.APIRequest{}
+	o := clientapi.ClientOptions{}
+	fs := newFlagSet("run", "[flags]")
+	connFlags(fs, &c, &o)
+	jobFlags(fs, &c)
+
+	deb := fs.Bool("debug", false, "Enable debugging")
+	pollPolicy := pollFlags(fs)
+
+	waitFor := fs.Bool("wait", true, "Wait for job to complete before returning final status")
+	timeout := fs.Duration("timeout", 0, "Kill the job if it has not completed within this duration, e.g. 30m (default no timeout)")
+	stream := fs.Bool("stream", true, "Stream the job's output as it runs while waiting, otherwise print it once the job completes")
+	parallel := fs.Int("parallel", 4, "Maximum number of matrix combinations to run at once, when the job has a matrix")
+	format := outputFormatFlag(fs)
+	exitCodeFlag(fs)
+	vars := varsFlag{}
+	fs.Var(vars, "set", "Set a variable for ${VAR} references in job-json, as key=value, overriding the environment - may be repeated")
+	tmplName := fs.String("template", "", "Name of a job template to run, instead of job-json")
+	params := varsFlag{}
+	fs.Var(params, "param", "Set a parameter of the -template, as key=value - may be repeated")
+	tmplDir := templateDirFlag(fs)
+	dry := fs.Bool("dry-run", false, "Show the job as it would be submitted, with content and env var values elided, and the calls to Vault and GoStint that would run it, without making any")
+
+	fs.Parse(args)
+	streamGiven := false
+	fs.Visit(func(f *flag.Flag) { streamGiven = streamGiven || f.Name == "stream" })
+	enableDebug = *deb
+	chkError(applyDefaults(fs))
+	applyJobDefaults(&c)
+
+	err := validate(c, o)
+	chkError(err)
+	chkError(validateOutputFormat(*format))
+	chkError(validateExitCodes())
+
+	err = resolveConn(&c)
+	chkError(err)
+	err = tryResolveFile(c.GoStintRole)
+	chkError(err)
+	if *tmplName != "" {
+		if *c.JobJSON != "" {
+			chkError(fmt.Errorf("-template and -job-json cannot be used together"))
+		}
+		*c.JobJSON, err = renderTemplate(*tmplDir, *tmplName, params, vars, *c.Strict)
+		chkError(err)
+	} else {
+		err = resolveJobJSON(c.JobJSON, vars, *c.Strict)
+		chkError(err)
+	}
+	matrix := *c.JobJSON != "" && clientapi.HasMatrix(*c.JobJSON)
+	if matrix && !*waitFor {
+		chkError(fmt.Errorf("-wait=false cannot be used with a matrix, its combinations are run as a batch and waited on"))
+	}
+	if matrix && streamGiven {
+		chkError(fmt.Errorf("-stream cannot be used with a matrix, the output of its combinations is not streamed"))
+	}
+
+	cl := newClient(&c, o, *deb, clientapi.WithPollPolicy(pollPolicy()))
+
+	err = cl.EncodeContent(c.Content)
+	chkError(err)
+
+	if *dry {
+		chkError(dryRun(cl, c, o, *waitFor))
+		os.Exit(0)
+	}
+
+	ctx, cancel := waitContext(*timeout)
+	defer cancel()
+
+	if matrix {
+		jobs, err := clientapi.MatrixJobs(c)
+		chkError(err)
+		started := time.Now()
+		results := runBatch(ctx, cl, jobs, *parallel)
+		if *format != formatText {
+			exitWithCombinedReport(*format, newReport("matrix", started, batchJobReports(results)))
+		}
+		if failed := printBatchResults(results); failed > 0 {
+			chkError(fmt.Errorf("%d of %d matrix combinations %w", failed, len(results), errJobsFailed))
+		}
+		os.Exit(0)
+	}
+
+	streaming := *stream && *waitFor && *format == formatText
+	if streaming {
+		c.Output = os.Stdout
+	}
+
+	started := time.Now()
+	res, err := cl.RunJob(ctx, &c, *waitFor)
+	if *format != formatText {
+		exitWithReport(*format, newJobReport("", res, err, started, time.Since(started)), err)
+	}
+	chkError(err)
+
+	if !*waitFor {
+		// not waiting, report the job so it can be followed up with status,
+		// wait, output or kill
+		fmt.Println(res.String())
+		os.Exit(0)
+	}
+	exitWithResult(res, streaming)
+}
+
+func cmdStatus(args []string) {
+	c := clientapi.APIRequest{}
+	o := clientapi.ClientOptions{}
+	fs := newFlagSet("status", "<job-id> [flags]")
+	format := outputFormatFlag(fs)
+	id, deb := jobCommand("status", args, &c, &o, fs)
+	chkError(validateOutputFormat(*format))
+	cl := newClient(&c, o, *deb)
+
+	ctx := context.Background()
+	err := withToken(ctx, cl, func(token string) error {
+		started := time.Now()
+		res, err := cl.GetJob(ctx, token, id)
+		if err != nil {
+			return err
+		}
+		if *format != formatText {
+			return writeJobReport(os.Stdout, *format, newJobReport("", res, nil, started, time.Since(started)))
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 1, ' ', 0)
+		fmt.Fprintf(tw, "ID:\t%s\n", res.ID)
+		fmt.Fprintf(tw, "Queue:\t%s\n", res.QName)
+		fmt.Fprintf(tw, "Status:\t%s\n", res.Status)
+		fmt.Fprintf(tw, "Image:\t%s\n", res.ContainerImage)
+		fmt.Fprintf(tw, "Node:\t%s\n", res.NodeUUID)
+		fmt.Fprintf(tw, "Submitted:\t%s\n", res.Submitted)
+		fmt.Fprintf(tw, "Started:\t%s\n", res.Started)
+		fmt.Fprintf(tw, "Ended:\t%s\n", res.Ended)
+		fmt.Fprintf(tw, "ReturnCode:\t%d\n", res.ReturnCode)
+		return tw.Flush()
+	})
+	chkError(err)
+}
+
+func cmdWait(args []string) {
+	c := clientapi.APIRequest{}
+	o := clientapi.ClientOptions{}
+	fs := newFlagSet("wait", "<job-id> [flags]")
+	pollPolicy := pollFlags(fs)
+	timeout := fs.Duration("timeout", 0, "Kill the job if it has not completed within this duration, e.g. 30m (default no timeout)")
+	stream := fs.Bool("stream", true, "Stream the job's output as it runs, otherwise print it once the job completes")
+	format := outputFormatFlag(fs)
+	exitCodeFlag(fs)
+	id, deb := jobCommand("wait", args, &c, &o, fs)
+	chkError(validateOutputFormat(*format))
+	chkError(validateExitCodes())
+	cl := newClient(&c, o, *deb, clientapi.WithPollPolicy(pollPolicy()))
+	streaming := *stream && *format == formatText
+
+	ctx, cancel := waitContext(*timeout)
+	defer cancel()
+
+	started := time.Now()
+	var res *clientapi.GetResponse
+	err := withToken(ctx, cl, func(token string) error {
+		var out io.Writer
+		if streaming {
+			out = os.Stdout
+		}
+		var err error
+		res, err = cl.WaitJobOrKill(ctx, token, id, out)
+		return err
+	})
+	if *format != formatText {
+		exitWithReport(*format, newJobReport("", res, err, started, time.Since(started)), err)
+	}
+	chkError(err)
+	exitWithResult(res, streaming)
+}
+
+func cmdOutput(args []string) {
+	c := clientapi.APIRequest{}
+	o := clientapi.ClientOptions{}
+	fs := newFlagSet("output", "<job-id> [flags]")
+	id, deb := jobCommand("output", args, &c, &o, fs)
+	cl := newClient(&c, o, *deb)
+
+	ctx := context.Background()
+	err := withToken(ctx, cl, func(token string) error {
+		res, err := cl.GetJob(ctx, token, id)
+		if err != nil {
+			return err
+		}
+		fmt.Print(res.Output)
+		return nil
+	})
+	chkError(err)
+}
+
+func cmdKill(args []string) {
+	c := clientapi.APIRequest{}
+	o := clientapi.ClientOptions{}
+	fs := newFlagSet("kill", "<job-id> [flags]")
+	id, deb := jobCommand("kill", args, &c, &o, fs)
+	cl := newClient(&c, o, *deb)
+
+	ctx := context.Background()
+	err := withToken(ctx, cl, func(token string) error {
+		return cl.KillJob(ctx, token, id)
+	})
+	chkError(err)
+	fmt.Printf("Job %s killed\n", id)
+}
+
+func cmdList(args []string) {
+	c := clientapi.APIRequest{}
+	o := clientapi.ClientOptions{}
+	fs := newFlagSet("list", "[flags]")
+	connFlags(fs, &c, &o)
+	deb := fs.Bool("debug", false, "Enable debugging")
+	qname := fs.String("qname", "", "Only list jobs in this queue")
+	status := fs.String("status", "", "Only list jobs with this status, e.g. queued, running, success, failed")
+	fs.Parse(args)
+	enableDebug = *deb
+	chkError(applyDefaults(fs))
+
+	chkError(validateConn(c, o))
+	chkError(resolveConn(&c))
+	cl := newClient(&c, o, *deb)
+
+	ctx := context.Background()
+	err := withToken(ctx, cl, func(token string) error {
+		jobs, err := cl.ListJobs(ctx, token, *qname, *status)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tQUEUE\tSTATUS\tIMAGE\tSUBMITTED\tRC")
+		for _, j := range jobs {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\n",
+				j.ID, j.QName, j.Status, j.ContainerImage, strings.TrimSpace(j.Submitted), j.ReturnCode)
+		}
+		return tw.Flush()
+	})
+	chkError(err)
+}
+
+func cmdBatch(args []string) {
+	c := clientapi.NewAPIRequest()
+	o := clientapi.ClientOptions{}
+	fs := newFlagSet("batch", "<manifest> [flags]")
+	connFlags(fs, c, &o)
+	c.GoStintRole = fs.String("gostint-approle", "gostint-role", "Vault App Role Name of GoStint to run jobs on (can read file e.g. '@gostint_role.txt')")
+	c.Strict = fs.Bool("strict", false, "Reject job fields unknown to this client, instead of warning and passing them to gostint unchanged")
+	deb := fs.Bool("debug", false, "Enable debugging")
+	parallel := fs.Int("parallel", 0, "Maximum number of jobs to run at once, overrides parallel in the manifest (default 4)")
+	pollPolicy := pollFlags(fs)
+	timeout := fs.Duration("timeout", 0, "Kill any jobs that have not completed within this duration, e.g. 30m (default no timeout)")
+	format := outputFormatFlag(fs)
+	pos := parseArgs(fs, args)
+	enableDebug = *deb
+	chkError(applyDefaults(fs))
+	if len(pos) != 1 {
+		fs.Usage()
+		chkError(fmt.Errorf("batch requires a single manifest file"))
+	}
+	chkError(validateOutputFormat(*format))
+
+	chkError(validateConn(*c, o))
+	chkError(resolveConn(c))
+	chkError(tryResolveFile(c.GoStintRole))
+	applyJobDefaults(c)
+
+	m, jobs, err := clientapi.LoadManifest(pos[0], *c)
+	chkError(err)
+	for _, j := range jobs {
+		checkJobJSON(j.Name, *j.Request.JobJSON, *c.Strict)
+	}
+	par := *parallel
+	if par == 0 {
+		par = m.Parallel
+	}
+	if par == 0 {
+		par = 4
+	}
+
+	cl := newClient(c, o, *deb, clientapi.WithPollPolicy(pollPolicy()))
+	ctx, cancel := waitContext(*timeout)
+	defer cancel()
+
+	started := time.Now()
+	results := runBatch(ctx, cl, jobs, par)
+	if *format != formatText {
+		exitWithCombinedReport(*format, newReport(pos[0], started, batchJobReports(results)))
+	}
+
+	failed := printBatchResults(results)
+	if failed > 0 {
+		chkError(fmt.Errorf("%d of %d jobs %w", failed, len(results), errJobsFailed))
+	}
+}
+
+// runBatch runs the jobs reporting progress to stderr, returning the results
+func runBatch(ctx context.Context, cl *clientapi.Client, jobs []clientapi.BatchJob, parallel int) []clientapi.BatchResult {
+	done := 0
+	fmt.Fprintf(os.Stderr, "Running %d jobs, %d at a time\n", len(jobs), parallel)
+	return cl.RunBatch(ctx, jobs, parallel, func(r clientapi.BatchResult) {
+		done++
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s: %s (%s)\n", done, len(jobs), r.Name, resultStatus(r), r.Duration.Round(time.Millisecond))
+	})
+}
+
+// printBatchResults prints a pass/fail table of the results, returning the
+// number that failed
+func printBatchResults(results []clientapi.BatchResult) int {
+	failed := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tRESULT\tSTATUS\tRC\tDURATION\tID")
+	for _, r := range results {
+		result := "PASS"
+		if !r.Passed() {
+			result = "FAIL"
+			failed++
+		}
+		id, rc := "", ""
+		if r.Response != nil {
+			id = r.Response.ID
+			rc = fmt.Sprintf("%d", r.Response.ReturnCode)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, result, resultStatus(r), rc, r.Duration.Round(time.Millisecond), id)
+	}
+	tw.Flush()
+	return failed
+}
+
+// resultStatus describes the outcome of a batch job
+func resultStatus(r clientapi.BatchResult) string {
+	if r.Err != nil {
+		return fmt.Sprintf("error: %s", r.Err)
+	}
+	return r.Response.Status
+}
+
+func cmdPipeline(args []string) {
+	c := clientapi.NewAPIRequest()
+	o := clientapi.ClientOptions{}
+	fs := newFlagSet("pipeline", "<pipeline> [flags]")
+	connFlags(fs, c, &o)
+	c.GoStintRole = fs.String("gostint-approle", "gostint-role", "Vault App Role Name of GoStint to run jobs on (can read file e.g. '@gostint_role.txt')")
+	c.Strict = fs.Bool("strict", false, "Reject job fields unknown to this client, instead of warning and passing them to gostint unchanged")
+	deb := fs.Bool("debug", false, "Enable debugging")
+	parallel := fs.Int("parallel", 4, "Maximum number of independent steps to run at once")
+	pollPolicy := pollFlags(fs)
+	timeout := fs.Duration("timeout", 0, "Kill any steps that have not completed within this duration of the pipeline starting, e.g. 30m (default no timeout)")
+	format := outputFormatFlag(fs)
+	pos := parseArgs(fs, args)
+	enableDebug = *deb
+	chkError(applyDefaults(fs))
+	if len(pos) != 1 {
+		fs.Usage()
+		chkError(fmt.Errorf("pipeline requires a single pipeline file"))
+	}
+	chkError(validateOutputFormat(*format))
+
+	chkError(validateConn(*c, o))
+	chkError(resolveConn(c))
+	chkError(tryResolveFile(c.GoStintRole))
+	applyJobDefaults(c)
+
+	p, err := clientapi.LoadPipeline(pos[0], *c)
+	chkError(err)
+	for _, step := range p.Steps {
+		checkJobFields(step.Name, clientapi.UnknownJobFields(step.Job), *c.Strict)
+	}
+
+	cl := newClient(c, o, *deb, clientapi.WithPollPolicy(pollPolicy()))
+	ctx, cancel := waitContext(*timeout)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "Running pipeline of %d steps\n", len(p.Steps))
+	started := time.Now()
+	results := cl.RunPipeline(ctx, p, *parallel, func(r clientapi.StepResult) {
+		if r.Skipped {
+			fmt.Fprintf(os.Stderr, "%s: skipped (%s)\n", r.Name, r.Reason)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s (%s)\n", r.Name, resultStatus(r.BatchResult), r.Duration.Round(time.Millisecond))
+	})
+	if *format != formatText {
+		reports := []jobReport{}
+		for _, r := range results {
+			reports = append(reports, stepJobReport(r))
+		}
+		exitWithCombinedReport(*format, newReport(pos[0], started, reports))
+	}
+
+	failed := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tRESULT\tSTATUS\tRC\tDURATION\tID")
+	for _, r := range results {
+		result, status := "PASS", ""
+		switch {
+		case r.Skipped:
+			result, status = "SKIP", r.Reason
+		case !r.Passed():
+			result = "FAIL"
+			failed++
+		}
+		id, rc := "", ""
+		if r.Response != nil {
+			id = r.Response.ID
+			rc = fmt.Sprintf("%d", r.Response.ReturnCode)
+		}
+		if !r.Skipped {
+			status = resultStatus(r.BatchResult)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, result, status, rc, r.Duration.Round(time.Millisecond), id)
+	}
+	tw.Flush()
+
+	if failed > 0 {
+		chkError(fmt.Errorf("%d of %d pipeline steps %w", failed, len(results), errJobsFailed))
+	}
+}
+
+func cmdValidate(args []string) {
+	fs := newFlagSet("validate", "<job-file>... [flags]")
+	strict := fs.Bool("strict", true, "Reject fields unknown to this client, otherwise only warn of them")
+	vars := varsFlag{}
+	fs.Var(vars, "set", "Set a variable for ${VAR} references in the job files, as key=value, overriding the environment - may be repeated")
+	deb := fs.Bool("debug", false, "Enable debugging")
+	pos := parseArgs(fs, args)
+	enableDebug = *deb
+	chkError(applyDefaults(fs))
+	if len(pos) == 0 {
+		fs.Usage()
+		chkError(fmt.Errorf("validate requires one or more job files"))
+	}
+
+	invalid := 0
+	for _, name := range pos {
+		b, err := ioutil.ReadFile(name)
+		chkError(err)
+		_, warnings, err := clientapi.LoadJob(name, string(b), vars, *strict)
+		var verr *clientapi.ValidationError
+		switch {
+		case errors.As(err, &verr):
+			invalid++
+			for _, p := range verr.Problems {
+				fmt.Println(p)
+			}
+		case err != nil:
+			invalid++
+			fmt.Println(err)
+		}
+		for _, w := range warnings {
+			fmt.Println(w)
+		}
+		if err == nil {
+			debug("%s is valid", name)
+		}
+	}
+	if invalid > 0 {
+		chkError(fmt.Errorf("%d of %d job files are invalid", invalid, len(pos)))
+	}
+}
+
+func cmdDoctor(args []string) {
+	c := clientapi.APIRequest{}
+	o := clientapi.ClientOptions{}
+	fs := newFlagSet("doctor", "[flags]")
+	connFlags(fs, &c, &o)
+	c.GoStintRole = fs.String("gostint-approle", "gostint-role", "Vault App Role Name of GoStint to check jobs can be run on (can read file e.g. '@gostint_role.txt')")
+	timeout := fs.Duration("timeout", 30*time.Second, "Time allowed for the checks")
+	deb := fs.Bool("debug", false, "Enable debugging")
+	if pos := parseArgs(fs, args); len(pos) != 0 {
+		fs.Usage()
+		chkError(fmt.Errorf("doctor takes no arguments"))
+	}
+	enableDebug = *deb
+	chkError(applyDefaults(fs))
+	chkError(validateConn(c, o))
+	chkError(resolveConn(&c))
+	chkError(tryResolveFile(c.GoStintRole))
+
+	cl := newClient(&c, o, *deb)
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	colors := map[clientapi.CheckStatus]*color.Color{
+		clientapi.CheckPass: color.New(color.FgGreen).Add(color.Bold),
+		clientapi.CheckWarn: color.New(color.FgYellow).Add(color.Bold),
+		clientapi.CheckFail: color.New(color.FgRed).Add(color.Bold),
+	}
+	failed := 0
+	checks := cl.Doctor(ctx, *c.GoStintRole)
+	for _, chk := range checks {
+		fmt.Printf("%s  %s: %s\n", colors[chk.Status].Sprint(strings.ToUpper(string(chk.Status))), chk.Name, chk.Detail)
+		if chk.Hint != "" {
+			fmt.Printf("      hint: %s\n", chk.Hint)
+		}
+		if chk.Status == clientapi.CheckFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		chkError(fmt.Errorf("%d of %d checks failed", failed, len(checks)))
+	}
+}
+
+func cmdPolicy(args []string) {
+	c := clientapi.APIRequest{}
+	o := clientapi.ClientOptions{}
+	fs := newFlagSet("policy", "generate [flags]")
+	fs.String("context", "", "Context of settings to use from the config file, instead of its current context - see the context command")
+	mountFlags(fs, &o)
+	c.GoStintRole = fs.String("gostint-approle", "gostint-role", "Vault App Role Name of GoStint to run jobs on (can read file e.g. '@gostint_role.txt')")
+	forRole := fs.String("for", "client", "Whose policy to generate: 'client', for the requestor's token or App Role, or 'gostint', for GoStint's App Role")
+	jobJSON := fs.String("job-json", "", "JSON or YAML job whose secret_refs GoStint's policy must allow it to read, with -for gostint (can read file e.g. '@job.yaml')")
+	vars := varsFlag{}
+	fs.Var(vars, "set", "Set a variable for ${VAR} references in job-json, as key=value, overriding the environment - may be repeated")
+	deb := fs.Bool("debug", false, "Enable debugging")
+	pos := parseArgs(fs, args)
+	enableDebug = *deb
+	chkError(applyDefaults(fs))
+	if len(pos) != 1 || pos[0] != "generate" {
+		fs.Usage()
+		chkError(fmt.Errorf("policy requires 'generate'"))
+	}
+	chkError(tryResolveFile(c.GoStintRole))
+
+	m := clientapi.Mounts{AppRole: strings.Trim(o.AppRoleMount, "/"), Transit: strings.Trim(o.TransitMount, "/")}
+	if m.AppRole == "" || m.Transit == "" {
+		chkError(fmt.Errorf("approle-mount and transit-mount must be specified"))
+	}
+
+	switch *forRole {
+	case "client":
+		if *jobJSON != "" {
+			chkError(fmt.Errorf("secret refs are read by GoStint with its own App Role, use -job-json with -for gostint"))
+		}
+		fmt.Print(clientapi.ClientPolicy(m, *c.GoStintRole))
+	case "gostint":
+		specs := []*clientapi.JobSpec{}
+		if *jobJSON != "" {
+			chkError(resolveJobJSON(jobJSON, vars, false))
+			spec := &clientapi.JobSpec{}
+			chkError(json.Unmarshal([]byte(*jobJSON), spec))
+			specs = append(specs, spec)
+		}
+		fmt.Print(clientapi.GoStintPolicy(m, *c.GoStintRole, specs...))
+	default:
+		chkError(fmt.Errorf("-for must be 'client' or 'gostint'"))
+	}
+}
+
+//go:generate sh -c "go run . schema > job.schema.json"
+
+func cmdSchema(args []string) {
+	fs := newFlagSet("schema", "")
+	strict := fs.Bool("strict", false, "Disallow fields unknown to this client, instead of allowing them as they are passed to gostint unchanged")
+	if pos := parseArgs(fs, args); len(pos) != 0 {
+		fs.Usage()
+		chkError(fmt.Errorf("schema takes no arguments"))
+	}
+	b, err := clientapi.JobSchemaJSON(*strict)
+	chkError(err)
+	os.Stdout.Write(b)
+}
+
+// templateDirFlag adds the flag for the folder of job templates
+func templateDirFlag(fs *flag.FlagSet) *string {
+	return fs.String("template-dir", clientapi.DefaultTemplateDir(), "Folder of job templates")
+}
+
+// renderTemplate returns the validated job JSON of a template with its
+// parameters, interpolating the template's own ${VAR} references but not
+// any in the parameter values
+func renderTemplate(dir, name string, params, vars map[string]string, strict bool) (string, error) {
+	t, err := clientapi.LoadTemplate(dir, name)
+	if err != nil {
+		return "", err
+	}
+	text, err := t.Render(params)
+	if err != nil {
+		return "", err
+	}
+	debug("Template %s rendered job:\n%s", name, text)
+	return loadJob("template "+name, text, vars, strict)
+}
+
+func cmdTemplates(args []string) {
+	fs := newFlagSet("templates", "list|show [name] [flags]")
+	dir := templateDirFlag(fs)
+	deb := fs.Bool("debug", false, "Enable debugging")
+	pos := parseArgs(fs, args)
+	enableDebug = *deb
+	chkError(applyDefaults(fs))
+
+	switch {
+	case len(pos) == 1 && pos[0] == "list":
+		tmpls, err := clientapi.ListTemplates(*dir)
+		chkError(err)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tPARAMETERS\tDESCRIPTION")
+		for _, t := range tmpls {
+			names := []string{}
+			for _, p := range t.Params {
+				names = append(names, p.Name)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Name, strings.Join(names, ","), t.Description)
+		}
+		tw.Flush()
+
+	case len(pos) == 2 && pos[0] == "show":
+		t, err := clientapi.LoadTemplate(*dir, pos[1])
+		chkError(err)
+		fmt.Printf("Name:        %s\nFile:        %s\nDescription: %s\n\nParameters:\n", t.Name, t.File, t.Description)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "  NAME\tTYPE\tREQUIRED\tDEFAULT\tALLOWED\tDESCRIPTION")
+		for _, p := range t.Params {
+			def, allowed := "", ""
+			if p.Default != nil {
+				def = fmt.Sprint(p.Default)
+			}
+			if len(p.Allowed) > 0 {
+				allowed = fmt.Sprint(p.Allowed)
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%t\t%s\t%s\t%s\n", p.Name, p.Type, p.Required, def, allowed, p.Description)
+		}
+		tw.Flush()
+		fmt.Printf("\nJob:\n%s", t.Job)
+
+	default:
+		fs.Usage()
+		chkError(fmt.Errorf("templates requires 'list' or 'show <name>'"))
+	}
+}
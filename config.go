@@ -0,0 +1,399 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ghodss/yaml"
+	"github.com/goethite/gostint-client/clientapi"
+)
+
+// config is the gostint client config file, holding named contexts of
+// settings keyed by flag name, e.g.
+//
+//	current-context: dev
+//	contexts:
+//	  dev:
+//	    url: https://127.0.0.1:13232
+//	    vault-url: https://127.0.0.1:18200
+//	    vault-token: "@.vault_token"
+//	    qname: play
+type config struct {
+	CurrentContext string                            `json:"current-context"`
+	Contexts       map[string]map[string]interface{} `json:"contexts"`
+}
+
+// vaultEnvVars are the standard Vault environment variables, which flags fall
+// back to after their own GOSTINT_ variables
+var vaultEnvVars = map[string]string{
+	"vault-url":       "VAULT_ADDR",
+	"vault-token":     "VAULT_TOKEN",
+	"vault-ca-cert":   "VAULT_CACERT",
+	"vault-namespace": "VAULT_NAMESPACE",
+}
+
+// noEnv are repeatable flags that can't be taken from a single variable
+var noEnv = map[string]bool{"set": true, "param": true}
+
+// jobDefaultSettings are the context settings that are defaults for a job's
+// fields, only used where the job doesn't set them, unlike their flags which
+// override the job
+var jobDefaultSettings = map[string]bool{"qname": true, "image": true}
+
+// jobDefaults are the job defaults of the context chosen by applyDefaults
+var jobDefaults map[string]string
+
+// applyJobDefaults sets the request's default queue and image from the
+// context chosen by applyDefaults
+func applyJobDefaults(c *clientapi.APIRequest) {
+	if v, ok := jobDefaults["qname"]; ok {
+		c.DefaultQName = &v
+	}
+	if v, ok := jobDefaults["image"]; ok {
+		c.DefaultImage = &v
+	}
+}
+
+// envName returns the environment variable for a flag, e.g. GOSTINT_VAULT_URL
+// for -vault-url
+func envName(flagName string) string {
+	return "GOSTINT_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// configFile returns the path of the config file, from GOSTINT_CONFIG else
+// gostint/config.yaml in the user's config folder
+func configFile() string {
+	if f := os.Getenv("GOSTINT_CONFIG"); f != "" {
+		return f
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gostint", "config.yaml")
+}
+
+// loadConfig loads the config file, which need not exist
+func loadConfig() (*config, error) {
+	cfg := &config{Contexts: map[string]map[string]interface{}{}}
+	file := configFile()
+	if file == "" {
+		return cfg, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if cfg.Contexts == nil {
+		cfg.Contexts = map[string]map[string]interface{}{}
+	}
+	known := contextKeys()
+	for name, settings := range cfg.Contexts {
+		for k := range settings {
+			if !known[k] {
+				return nil, fmt.Errorf("%s: context %s: unknown setting '%s'", file, name, k)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// contextKeys returns the flags that may be set in a context: connection,
+// authentication and TLS settings, the gostint role, and the default queue
+// and image
+func contextKeys() map[string]bool {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	connFlags(fs, &clientapi.APIRequest{}, &clientapi.ClientOptions{})
+	known := map[string]bool{"gostint-approle": true, "qname": true, "image": true}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name != "context" {
+			known[f.Name] = true
+		}
+	})
+	return known
+}
+
+// context returns the settings of the named context, or the current context
+// if name is empty, which may be nil if there is none
+func (cfg *config) context(name string) (map[string]interface{}, error) {
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	if name == "" {
+		return nil, nil
+	}
+	settings, ok := cfg.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("context '%s' not found in %s", name, configFile())
+	}
+	return settings, nil
+}
+
+// settingString returns a context setting as a flag value
+func settingString(v interface{}) string {
+	if f, ok := v.(float64); ok && f == float64(int64(f)) {
+		return fmt.Sprint(int64(f))
+	}
+	return fmt.Sprint(v)
+}
+
+// applyDefaults sets the flags not given on the command line from their
+// GOSTINT_ environment variables (or standard VAULT_ ones), else from the
+// context chosen by -context or the current context of the config file.  The
+// context's job defaults are kept for applyJobDefaults rather than set as
+// flags.  With -debug, where each value came from is logged.
+func applyDefaults(fs *flag.FlagSet) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	name := os.Getenv(envName("context"))
+	if f := fs.Lookup("context"); f != nil && given["context"] {
+		name = f.Value.String()
+	}
+	settings, err := cfg.context(name)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+
+	sources := map[string]string{}
+	var ferr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if ferr != nil {
+			return
+		}
+		if given[f.Name] {
+			sources[f.Name] = "command line"
+			return
+		}
+		envs := []string{envName(f.Name)}
+		if env, ok := vaultEnvVars[f.Name]; ok {
+			envs = append(envs, env)
+		}
+		for _, env := range envs {
+			if v := os.Getenv(env); v != "" && !noEnv[f.Name] {
+				if err := fs.Set(f.Name, v); err != nil {
+					ferr = fmt.Errorf("env var %s: %s", env, err)
+				}
+				sources[f.Name] = "env var " + env
+				return
+			}
+		}
+		if v, ok := settings[f.Name]; ok && !jobDefaultSettings[f.Name] {
+			if err := fs.Set(f.Name, settingString(v)); err != nil {
+				ferr = fmt.Errorf("context %s setting %s: %s", name, f.Name, err)
+			}
+			sources[f.Name] = "context " + name
+		}
+	})
+	if ferr != nil {
+		return ferr
+	}
+
+	// an auth method given with higher precedence replaces the other, e.g. a
+	// -vault-token replaces the context's approle.  A VAULT_TOKEN lying around
+	// in the environment gives way to any approle.
+	rank := func(name string) int {
+		src := sources[name]
+		switch {
+		case src == "command line":
+			return 4
+		case strings.HasPrefix(src, "env var GOSTINT_"):
+			return 3
+		case strings.HasPrefix(src, "context"):
+			return 2
+		case src != "":
+			return 1
+		}
+		return 0
+	}
+	unset := func(names ...string) {
+		for _, n := range names {
+			if fs.Lookup(n) != nil {
+				fs.Set(n, "")
+				delete(sources, n)
+			}
+		}
+	}
+	tokenRank := rank("vault-token")
+	roleRank := rank("vault-roleid")
+	if r := rank("vault-secretid"); r > roleRank {
+		roleRank = r
+	}
+	switch {
+	case tokenRank > roleRank && roleRank > 0:
+		unset("vault-roleid", "vault-secretid")
+	case roleRank > tokenRank && tokenRank > 0:
+		unset("vault-token")
+	}
+
+	if f := fs.Lookup("debug"); f != nil {
+		enableDebug = f.Value.String() == "true"
+	}
+	jobDefaults = map[string]string{}
+	for k := range jobDefaultSettings {
+		if v, ok := settings[k]; ok {
+			jobDefaults[k] = settingString(v)
+			debug("default %s=%s (context %s)", k, jobDefaults[k], name)
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if v == "" {
+			return
+		}
+		src, ok := sources[f.Name]
+		if !ok {
+			src = "default"
+		}
+		if secretSettings[f.Name] && !strings.HasPrefix(v, "@") {
+			v = "********"
+		}
+		debug("-%s=%s (%s)", f.Name, v, src)
+	})
+	return nil
+}
+
+var currentContextRe = regexp.MustCompile(`(?m)^current-context:.*$`)
+
+// useContext makes name the current context, editing the config file in
+// place to keep its comments
+func useContext(name string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if _, err = cfg.context(name); err != nil {
+		return err
+	}
+	file := configFile()
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	line := "current-context: " + name
+	if currentContextRe.Match(b) {
+		b = currentContextRe.ReplaceAllLiteral(b, []byte(line))
+	} else {
+		b = append([]byte(line+"\n"), b...)
+	}
+	return ioutil.WriteFile(file, b, 0600)
+}
+
+// secretSettings are masked by context show
+var secretSettings = map[string]bool{"vault-token": true, "vault-secretid": true}
+
+func cmdContext(args []string) {
+	fs := newFlagSet("context", "use|list|show [name] [flags]")
+	deb := fs.Bool("debug", false, "Enable debugging")
+	pos := parseArgs(fs, args)
+	enableDebug = *deb
+
+	switch {
+	case len(pos) == 2 && pos[0] == "use":
+		chkError(useContext(pos[1]))
+		fmt.Printf("Switched to context %s\n", pos[1])
+
+	case len(pos) == 1 && pos[0] == "list":
+		cfg, err := loadConfig()
+		chkError(err)
+		names := []string{}
+		for name := range cfg.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CURRENT\tNAME\tURL\tVAULT-URL")
+		for _, name := range names {
+			cur := ""
+			if name == cfg.CurrentContext {
+				cur = "*"
+			}
+			s := cfg.Contexts[name]
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", cur, name, settingOrEmpty(s, "url"), settingOrEmpty(s, "vault-url"))
+		}
+		tw.Flush()
+
+	case (len(pos) == 1 || len(pos) == 2) && pos[0] == "show":
+		cfg, err := loadConfig()
+		chkError(err)
+		name := cfg.CurrentContext
+		if len(pos) == 2 {
+			name = pos[1]
+		}
+		if name == "" {
+			chkError(fmt.Errorf("no current context, see 'context use'"))
+		}
+		settings, err := cfg.context(name)
+		chkError(err)
+		keys := []string{}
+		for k := range settings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Printf("Context %s:\n", name)
+		for _, k := range keys {
+			v := settingString(settings[k])
+			if secretSettings[k] && !strings.HasPrefix(v, "@") {
+				v = "********"
+			}
+			fmt.Printf("  %s: %s\n", k, v)
+		}
+
+	default:
+		fs.Usage()
+		chkError(fmt.Errorf("context requires 'use <name>', 'list' or 'show [name]'"))
+	}
+}
+
+func settingOrEmpty(settings map[string]interface{}, key string) string {
+	if v, ok := settings[key]; ok {
+		return settingString(v)
+	}
+	return ""
+}
@@ -0,0 +1,225 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goethite/gostint-client/clientapi"
+)
+
+const testConfig = `# my contexts
+current-context: dev
+contexts:
+  dev:
+    url: https://dev:3232
+    vault-token: "@.dev_token"
+    retries: 5
+    qname: play
+  prod:
+    url: https://prod:3232
+    vault-roleid: prod-role
+    image: alpine
+`
+
+// writeConfig points GOSTINT_CONFIG at a config file of the content, with
+// the environment variables that would override it cleared
+func writeConfig(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOSTINT_CONFIG", file)
+	for _, env := range []string{"GOSTINT_CONTEXT", "GOSTINT_URL", "GOSTINT_VAULT_URL", "GOSTINT_VAULT_TOKEN", "GOSTINT_VAULT_ROLEID", "GOSTINT_RETRIES", "VAULT_ADDR", "VAULT_TOKEN"} {
+		t.Setenv(env, "")
+	}
+	return file
+}
+
+// parseDefaults parses the run flags from args then applies their defaults
+func parseDefaults(t *testing.T, args ...string) (*flag.FlagSet, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c := &clientapi.APIRequest{}
+	connFlags(fs, c, &clientapi.ClientOptions{})
+	jobFlags(fs, c)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs, applyDefaults(fs)
+}
+
+func flagValue(fs *flag.FlagSet, name string) string {
+	return fs.Lookup(name).Value.String()
+}
+
+func TestContexts(t *testing.T) {
+	writeConfig(t, testConfig)
+
+	fs, err := parseDefaults(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"url": "https://dev:3232", "vault-token": "@.dev_token", "retries": "5", "qname": ""} {
+		if got := flagValue(fs, name); got != want {
+			t.Errorf("-%s: got %q, want %q from the current context", name, got, want)
+		}
+	}
+	// qname is a default for the job, not an override of it
+	if jobDefaults["qname"] != "play" {
+		t.Errorf("got job defaults %v", jobDefaults)
+	}
+
+	fs, err = parseDefaults(t, "-context", "prod", "-url", "https://other:3232")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := flagValue(fs, "url"); got != "https://other:3232" {
+		t.Errorf("-url: got %q, want the command line's", got)
+	}
+	if got := flagValue(fs, "vault-roleid"); got != "prod-role" {
+		t.Errorf("-vault-roleid: got %q, want the prod context's", got)
+	}
+	if jobDefaults["image"] != "alpine" || jobDefaults["qname"] != "" {
+		t.Errorf("got job defaults %v, want prod's", jobDefaults)
+	}
+	c := clientapi.APIRequest{}
+	applyJobDefaults(&c)
+	if c.DefaultImage == nil || *c.DefaultImage != "alpine" || c.DefaultQName != nil {
+		t.Errorf("got default image %v and qname %v", c.DefaultImage, c.DefaultQName)
+	}
+
+	if _, err = parseDefaults(t, "-context", "test"); err == nil || !strings.Contains(err.Error(), "context 'test' not found") {
+		t.Errorf("got %v, want context not found", err)
+	}
+
+	writeConfig(t, "contexts:\n  dev:\n    colour: red\n")
+	if _, err = parseDefaults(t); err == nil || !strings.Contains(err.Error(), "context dev: unknown setting 'colour'") {
+		t.Errorf("got %v, want unknown setting", err)
+	}
+
+	// without a config file there are no defaults
+	t.Setenv("GOSTINT_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	if fs, err = parseDefaults(t); err != nil || flagValue(fs, "url") != "" {
+		t.Errorf("got url %q and %v without a config file", flagValue(fs, "url"), err)
+	}
+}
+
+func TestUseContext(t *testing.T) {
+	file := writeConfig(t, testConfig)
+	if err := useContext("prod"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Replace(testConfig, "current-context: dev", "current-context: prod", 1); string(b) != want {
+		t.Errorf("got config:\n%s\nwant:\n%s", b, want)
+	}
+
+	if err := useContext("test"); err == nil {
+		t.Error("used a context that doesn't exist")
+	}
+
+	file = writeConfig(t, "contexts:\n  dev: {}\n")
+	if err := useContext("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ = ioutil.ReadFile(file); !strings.HasPrefix(string(b), "current-context: dev\ncontexts:") {
+		t.Errorf("got config:\n%s", b)
+	}
+}
+
+func TestEnvDefaults(t *testing.T) {
+	writeConfig(t, testConfig)
+	t.Setenv("GOSTINT_URL", "https://env:3232")
+	t.Setenv("VAULT_ADDR", "https://vault:8200")
+	t.Setenv("GOSTINT_RETRIES", "7")
+
+	fs, err := parseDefaults(t, "-retries", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"url":       "https://env:3232",   // GOSTINT_ var over the context
+		"vault-url": "https://vault:8200", // falls back to the VAULT_ var
+		"retries":   "1",                  // command line over the GOSTINT_ var
+	} {
+		if got := flagValue(fs, name); got != want {
+			t.Errorf("-%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	t.Setenv("GOSTINT_VAULT_URL", "https://gostint-vault:8200")
+	t.Setenv("GOSTINT_CONTEXT", "prod")
+	if fs, err = parseDefaults(t); err != nil {
+		t.Fatal(err)
+	}
+	if got := flagValue(fs, "vault-url"); got != "https://gostint-vault:8200" {
+		t.Errorf("-vault-url: got %q, want GOSTINT_VAULT_URL over VAULT_ADDR", got)
+	}
+	if got := flagValue(fs, "vault-roleid"); got != "prod-role" {
+		t.Errorf("-vault-roleid: got %q, want the GOSTINT_CONTEXT's", got)
+	}
+
+	t.Setenv("GOSTINT_RETRIES", "lots")
+	if _, err = parseDefaults(t); err == nil || !strings.Contains(err.Error(), "env var GOSTINT_RETRIES") {
+		t.Errorf("got %v, want the bad env var", err)
+	}
+}
+
+func TestEnvDefaultsAuth(t *testing.T) {
+	writeConfig(t, testConfig)
+	for _, tc := range []struct {
+		name        string
+		env         map[string]string
+		args        []string
+		token, role string
+	}{
+		{"context approle over VAULT_TOKEN", map[string]string{"VAULT_TOKEN": "s.env", "GOSTINT_CONTEXT": "prod"}, nil, "", "prod-role"},
+		{"GOSTINT_VAULT_TOKEN over context approle", map[string]string{"GOSTINT_VAULT_TOKEN": "s.env", "GOSTINT_CONTEXT": "prod"}, nil, "s.env", ""},
+		{"command line approle over GOSTINT_VAULT_TOKEN", map[string]string{"GOSTINT_VAULT_TOKEN": "s.env"}, []string{"-vault-roleid", "cli-role"}, "", "cli-role"},
+		{"command line token over context approle", nil, []string{"-context", "prod", "-vault-token", "s.cli"}, "s.cli", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for env, v := range tc.env {
+				t.Setenv(env, v)
+			}
+			fs, err := parseDefaults(t, tc.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token, role := flagValue(fs, "vault-token"), flagValue(fs, "vault-roleid"); token != tc.token || role != tc.role {
+				t.Errorf("got token %q and role %q, want %q and %q", token, role, tc.token, tc.role)
+			}
+		})
+	}
+}
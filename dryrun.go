@@ -0,0 +1,120 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/goethite/gostint-client/clientapi"
+)
+
+// dryRun prints what running the job would do, without doing any of it: the
+// job as it would be submitted, with its content and env var values elided,
+// the size of its content and the calls to Vault and GoStint that would run
+// it
+func dryRun(cl *clientapi.Client, c clientapi.APIRequest, o clientapi.ClientOptions, waitFor bool) error {
+	names := []string{""}
+	specs := []*clientapi.JobSpec{}
+	if *c.JobJSON != "" && clientapi.HasMatrix(*c.JobJSON) {
+		jobs, err := clientapi.MatrixJobs(c)
+		if err != nil {
+			return err
+		}
+		names = names[:0]
+		for _, j := range jobs {
+			spec, err := j.Request.JobSpec()
+			if err != nil {
+				return fmt.Errorf("%s: %w", j.Name, err)
+			}
+			names = append(names, j.Name)
+			specs = append(specs, spec)
+		}
+	} else {
+		spec, err := c.JobSpec()
+		if err != nil {
+			return err
+		}
+		specs = append(specs, spec)
+	}
+
+	for i, spec := range specs {
+		if err := spec.Validate(); err != nil {
+			return err
+		}
+		if names[i] == "" {
+			fmt.Println("Job:")
+		} else {
+			fmt.Printf("Job %s:\n", names[i])
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(spec.Elided()); err != nil {
+			return err
+		}
+		if strings.HasPrefix(spec.Content, "targz,") {
+			encoded := strings.TrimPrefix(spec.Content, "targz,")
+			raw, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return fmt.Errorf("content: %s", err)
+			}
+			fmt.Printf("Content: %d bytes tar.gz, %d bytes encoded\n", len(raw), len(encoded))
+		}
+		fmt.Println()
+	}
+
+	fmt.Println("Calls that would run the job, none of which are made in a dry run:")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	endpoint := ""
+	steps := cl.Plan(clientapi.RunOptions{
+		GoStintRole:    *c.GoStintRole,
+		IdempotencyKey: *c.IdempotencyKey,
+		Wait:           waitFor,
+	})
+	for i, st := range steps {
+		fmt.Fprintf(tw, "  %d.\t%s\t%s\t%s\t%s\n", i+1, st.Service, st.Method, cl.StepURL(st), st.Purpose)
+		if st.Service == "gostint" && st.Method == "POST" {
+			endpoint = st.Method + " " + cl.StepURL(st)
+		}
+	}
+	tw.Flush()
+
+	fmt.Printf("\nGoStint endpoint: %s", endpoint)
+	if o.Via != "" {
+		fmt.Printf(" via %s", o.Via)
+	}
+	fmt.Println()
+	if o.VaultVia != "" {
+		fmt.Printf("Vault via: %s\n", o.VaultVia)
+	}
+	return nil
+}
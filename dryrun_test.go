@@ -0,0 +1,116 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/goethite/gostint-client/clientapi"
+)
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- b
+	}()
+	err = fn()
+	w.Close()
+	return string(<-out), err
+}
+
+func TestDryRun(t *testing.T) {
+	cl, err := clientapi.NewClient(
+		clientapi.WithURL("https://gostint:3232"),
+		clientapi.WithVaultURL("https://vault:8200"),
+		clientapi.WithVaultToken("root"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	str := func(s string) *string { return &s }
+
+	out, err := captureStdout(t, func() error {
+		return dryRun(cl, clientapi.APIRequest{
+			JobJSON:        str(`{"qname": "play", "container_image": "alpine", "env_vars": ["PASSWORD=hunter2"]}`),
+			GoStintRole:    str("gostint-role"),
+			IdempotencyKey: str("deploy-1"),
+		}, clientapi.ClientOptions{Via: "socks5://bastion:1080"}, true)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Job:\n{\n",
+		`"PASSWORD=(7 bytes elided)"`,
+		"vault    GET   https://vault:8200/v1/auth/token/lookup-self",
+		"https://gostint:3232/v1/api/job?idempotency_key=deploy-1",
+		"poll the job until it completes",
+		"\nGoStint endpoint: POST https://gostint:3232/v1/api/job via socks5://bastion:1080\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dry run output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "hunter2") {
+		t.Errorf("dry run showed an env var's value:\n%s", out)
+	}
+
+	out, err = captureStdout(t, func() error {
+		return dryRun(cl, clientapi.APIRequest{
+			JobJSON:        str(`{"container_image": "alpine", "matrix": {"qname": ["a", "b"]}}`),
+			GoStintRole:    str("gostint-role"),
+			IdempotencyKey: str(""),
+		}, clientapi.ClientOptions{}, false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Job qname=a:\n", "Job qname=b:\n", "get the job's state"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("matrix dry run output missing %q:\n%s", want, out)
+		}
+	}
+
+	_, err = captureStdout(t, func() error {
+		return dryRun(cl, clientapi.APIRequest{JobJSON: str(`{"qname": "play"}`), GoStintRole: str(""), IdempotencyKey: str("")}, clientapi.ClientOptions{}, true)
+	})
+	if err == nil {
+		t.Error("dry ran an invalid job")
+	}
+}
@@ -27,11 +27,19 @@ SOFTWARE.
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/goethite/gostint-client/clientapi"
 
@@ -47,8 +55,7 @@ func debug(format string, a ...interface{}) {
 	clientapi.Debug(format, a...)
 }
 
-func validate(c clientapi.APIRequest) error {
-	debug("Validating command line arguments")
+func validateConn(c clientapi.APIRequest, o clientapi.ClientOptions) error {
 	if *c.URL == "" {
 		return fmt.Errorf("url must be specified")
 	}
@@ -69,13 +76,44 @@ func validate(c clientapi.APIRequest) error {
 		return fmt.Errorf("vault-token cannot be used with vault-roleid")
 	}
 
-	if *c.ImagePullPolicy != "IfNotPresent" && *c.ImagePullPolicy != "Always" {
-		return fmt.Errorf("invalid image-pull-policy, must be 'IfNotPresetn' or 'Always'")
+	if (o.ClientCert == "") != (o.ClientKey == "") {
+		return fmt.Errorf("client-cert and client-key must be specified together")
 	}
 
 	return nil
 }
 
+func validate(c clientapi.APIRequest, o clientapi.ClientOptions) error {
+	debug("Validating command line arguments")
+	if err := validateConn(c, o); err != nil {
+		return err
+	}
+
+	return validateJobFlags(c)
+}
+
+// validateJobFlags checks the job fields given as flags, reporting every
+// problem found
+func validateJobFlags(c clientapi.APIRequest) error {
+	problems := []clientapi.Problem{}
+	for _, f := range []struct {
+		flag, field string
+		value       *string
+	}{
+		{"image-pull-policy", "image_pull_policy", c.ImagePullPolicy},
+		{"entrypoint", "entrypoint", c.EntryPoint},
+		{"run", "run", c.Run},
+		{"env-vars", "env_vars", c.EnvVars},
+		{"secret-refs", "secret_refs", c.SecretRefs},
+		{"secret-filetype", "secret_file_type", c.SecretFileType},
+	} {
+		if *f.value != "" {
+			problems = append(problems, clientapi.ValidateJobField("-"+f.flag, f.field, *f.value)...)
+		}
+	}
+	return clientapi.ProblemsError(problems)
+}
+
 func tryResolveFile(p *string) error {
 	if strings.HasPrefix(*p, "@") {
 		debug("Resolving file argument %s", *p)
@@ -89,78 +127,321 @@ func tryResolveFile(p *string) error {
 	return nil
 }
 
+// resolveJobJSON resolves a job given inline or as '@file', in JSON or YAML,
+// to JSON, interpolating ${VAR} references from vars or the environment and
+// validating it
+func resolveJobJSON(p *string, vars map[string]string, strict bool) error {
+	if *p == "" {
+		return nil
+	}
+	name, text := "job-json", *p
+	if strings.HasPrefix(*p, "@") {
+		name = strings.TrimPrefix(*p, "@")
+		debug("Resolving file argument %s", *p)
+		// read untrimmed, to report errors on the right line
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		text = string(b)
+	}
+	js, err := loadJob(name, text, vars, strict)
+	if err != nil {
+		return err
+	}
+	*p = js
+	return nil
+}
+
+// loadJob interpolates, validates and converts a job to JSON, warning of any
+// problems that don't prevent submitting it
+func loadJob(name string, text string, vars map[string]string, strict bool) (string, error) {
+	js, warnings, err := clientapi.LoadJob(name, text, vars, strict)
+	for _, w := range warnings {
+		warn("%s", w)
+	}
+	return js, err
+}
+
+// varsFlag is a repeatable key=value flag
+type varsFlag map[string]string
+
+func (v varsFlag) String() string {
+	kvs := []string{}
+	for k, val := range v {
+		kvs = append(kvs, k+"="+val)
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+func (v varsFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return fmt.Errorf("must be key=value")
+	}
+	v[s[:i]] = s[i+1:]
+	return nil
+}
+
+// Exit codes for errors talking to gostint, after sysexits(3), so scripts can
+// tell them apart from a failed job
+const (
+	exitError       = 1
+	exitClientError = 2  // any other error, with -exit-code=client
+	exitNotFound    = 66 // EX_NOINPUT
+	exitUnavailable = 69 // EX_UNAVAILABLE
+	exitTempFail    = 75 // EX_TEMPFAIL
+	exitNoPerm      = 77 // EX_NOPERM
+)
+
+// How a completed job sets the exit code, by -exit-code
+const (
+	exitCodesJob    = "job"    // the job's return code
+	exitCodesClient = "client" // 1 if it failed, so it can't be taken for a client error
+)
+
+var exitCodes = exitCodesJob
+
+// exitCodeFlag adds the flag choosing how a completed job sets the exit code
+func exitCodeFlag(fs *flag.FlagSet) {
+	fs.StringVar(&exitCodes, "exit-code", exitCodesJob, "Exit code of a completed job: job, its return code, or client, 1 if it failed, so it can't be confused with the codes of the client's own errors")
+}
+
+func validateExitCodes() error {
+	if exitCodes != exitCodesJob && exitCodes != exitCodesClient {
+		return fmt.Errorf("invalid exit-code '%s', must be job or client", exitCodes)
+	}
+	return nil
+}
+
+// errJobsFailed ends the errors reporting that jobs of a batch failed, rather
+// than the client
+var errJobsFailed = errors.New("failed")
+
+// errorExitCode returns the exit code for err
+func errorExitCode(err error) int {
+	switch {
+	case errors.Is(err, errJobsFailed):
+		return exitError
+	case errors.Is(err, clientapi.ErrUnauthorized):
+		return exitNoPerm
+	case errors.Is(err, clientapi.ErrNotFound):
+		return exitNotFound
+	case clientapi.IsRetryable(err):
+		return exitTempFail
+	case errors.Is(err, clientapi.ErrServer):
+		return exitUnavailable
+	case exitCodes == exitCodesClient:
+		return exitClientError
+	}
+	return exitError
+}
+
 func chkError(err error) {
 	if err != nil {
 		// color.HiRed(fmt.Sprintf("Error: %s", err.Error()))
 		var red = color.New(color.FgRed).Add(color.Bold).SprintfFunc()
 		fmt.Fprintln(color.Error, red("Error: %s", err.Error()))
 		// panic(err)
-		os.Exit(1)
+		os.Exit(errorExitCode(err))
 	}
 }
 
-func main() {
-	c := clientapi.APIRequest{}
-	c.AppRoleID = flag.String("vault-roleid", "", "Requestor's Vault App Role ID (can read file e.g. '@role_id.txt')")
-	c.AppSecretID = flag.String("vault-secretid", "", "Requestor's Vault App Secret ID (can read file e.g. '@secret_id.txt')")
-	c.Token = flag.String("vault-token", "", "Requestor's Vault token - used instead of App Role (can read file e.g. '@token.txt')")
+func warn(format string, a ...interface{}) {
+	var yellow = color.New(color.FgYellow).Add(color.Bold).SprintfFunc()
+	fmt.Fprintln(color.Error, yellow(format, a...))
+}
 
-	c.GoStintRole = flag.String("gostint-approle", "gostint-role", "Vault App Role Name of GoStint to run job on (can read file e.g. '@gostint_role.txt')")
+// handleSignals cancels the job's context on SIGTERM, or on SIGINT once the
+// user confirms killing the job - a second SIGINT forces it
+func handleSignals(cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
-	c.JobJSON = flag.String("job-json", "", "JSON Job request")
+	go func() {
+		sig := <-sigs
+		fi, err := os.Stdin.Stat()
+		interactive := err == nil && fi.Mode()&os.ModeCharDevice != 0
+		if sig == syscall.SIGTERM || !interactive {
+			warn("Received %s, killing the job", sig)
+			cancel()
+			return
+		}
 
-	c.QName = flag.String("qname", "", "Job Queue to submit to, overrides value in job-json")
-	c.ContainerImage = flag.String("image", "", "Docker image to run job within, overrides value in job-json")
-	c.ImagePullPolicy = flag.String("image-pull-policy", "IfNotPresent", "Docker image pull policy: IfNotPresent or Always")
-	c.Content = flag.String("content", "", "Folder or targz to inject into the container relative to root '/' folder, overrides value in job-json")
-	c.EntryPoint = flag.String("entrypoint", "", "JSON array of string parts defining the container's entrypoint, e.g.: '[\"ansible\"]', overrides value in job-json")
-	c.Run = flag.String("run", "", "JSON array of string parts defining the command to run in the container - aka the job, e.g.: '[\"-m\", \"ping\", \"127.0.0.1\"]', overrides value in job-json")
-	c.WorkingDir = flag.String("run-dir", "", "Working directory within the container to run the job")
-	c.EnvVars = flag.String("env-vars", "", "JSON array of strings providing envronment variables to be passed to the job container, e.g.: '[\"MYVAR=value\"]'")
-	c.SecretRefs = flag.String("secret-refs", "", "JSON array of strings providing paths to secrets in the Vault to be injected into the job's container, e.g.: '[\"mysecret@secret/data/my-secret.my-value\", ...]', overrides value in job-json")
-	c.SecretFileType = flag.String("secret-filetype", "yaml", "Injected secret file type, can be either 'yaml' (default) or 'json', overrides value in job-json")
-	c.ContOnWarnings = flag.Bool("cont-on-warnings", false, "Continue to run job even if vault reported warnings when looking up secret refs, overrides value in job-json")
+		for {
+			warn("Interrupted - kill the remote job? [y/N] (interrupt again to force)")
+			answer := make(chan string, 1)
+			go func() {
+				line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+				answer <- strings.ToLower(strings.TrimSpace(line))
+			}()
+			select {
+			case <-sigs:
+				warn("Forced, killing the job")
+				cancel()
+				return
+			case a := <-answer:
+				if a == "y" || a == "yes" {
+					cancel()
+					return
+				}
+				warn("Continuing to wait for the job")
+			}
+			<-sigs
+		}
+	}()
+}
 
-	c.URL = flag.String("url", "", "GoStint API URL, e.g. https://somewhere:3232")
-	c.VaultURL = flag.String("vault-url", "", "Vault API URL, e.g. https://your-vault:8200 - defaults to env var VAULT_ADDR")
+// connFlags adds the flags for connecting and authenticating to gostint and
+// vault
+func connFlags(fs *flag.FlagSet, c *clientapi.APIRequest, o *clientapi.ClientOptions) {
+	fs.String("context", "", "Context of settings to use from the config file, instead of its current context - see the context command")
 
-	deb := flag.Bool("debug", false, "Enable debugging")
-	pollIntervalSecs := flag.Int("poll-interval", 1, "Overide default poll interval for results (in seconds)")
+	c.AppRoleID = fs.String("vault-roleid", "", "Requestor's Vault App Role ID (can read file e.g. '@role_id.txt')")
+	c.AppSecretID = fs.String("vault-secretid", "", "Requestor's Vault App Secret ID (can read file e.g. '@secret_id.txt')")
+	c.Token = fs.String("vault-token", "", "Requestor's Vault token - used instead of App Role (can read file e.g. '@token.txt') - defaults to env var VAULT_TOKEN")
 
-	waitFor := flag.Bool("wait", true, "Wait for job to complete before returning final status")
+	c.URL = fs.String("url", "", "GoStint API URL, e.g. https://somewhere:3232")
+	c.VaultURL = fs.String("vault-url", "", "Vault API URL, e.g. https://your-vault:8200 - defaults to env var VAULT_ADDR")
+	fs.StringVar(&o.VaultCACert, "vault-ca-cert", "", "PEM CA bundle file to verify Vault's certificate - defaults to env var VAULT_CACERT")
+	fs.StringVar(&o.VaultNamespace, "vault-namespace", "", "Vault Enterprise namespace - defaults to env var VAULT_NAMESPACE")
 
-	flag.Parse()
-	enableDebug = *deb
+	fs.StringVar(&o.CACert, "ca-cert", "", "PEM CA bundle file to verify the GoStint API's certificate, defaults to the system roots")
+	fs.StringVar(&o.CAPath, "ca-path", "", "Folder of PEM CA certificates to verify the GoStint API's certificate")
+	fs.StringVar(&o.TLSServerName, "tls-server-name", "", "Server name to verify in the GoStint API's certificate, if different to the url host")
+	fs.StringVar(&o.ClientCert, "client-cert", "", "PEM client certificate for mutual TLS with the GoStint API")
+	fs.StringVar(&o.ClientKey, "client-key", "", "PEM private key for -client-cert")
+	fs.StringVar(&o.PinSHA256, "pin-sha256", "", "Comma separated base64 sha256 hashes of the GoStint API's public key (SPKI) to pin")
+	fs.BoolVar(&o.Insecure, "insecure", false, "Skip verification of the GoStint API's certificate - INSECURE, for testing only")
 
-	err := validate(c)
-	chkError(err)
+	fs.StringVar(&o.Via, "via", "", "Comma separated chain of hops to route to the GoStint API through, e.g. 'http://gw:3128,socks5://bastion:1080,ssh://user@jumphost:22'")
+	fs.StringVar(&o.VaultVia, "vault-via", "", "Comma separated chain of hops to route to Vault through, as for -via")
 
-	err = tryResolveFile(c.AppRoleID)
-	chkError(err)
-	err = tryResolveFile(c.AppSecretID)
-	chkError(err)
-	err = tryResolveFile(c.Token)
-	chkError(err)
-	err = tryResolveFile(c.GoStintRole)
-	chkError(err)
-	err = tryResolveFile(c.JobJSON)
-	chkError(err)
+	mountFlags(fs, o)
 
-	err = clientapi.EncodeContent(c.Content)
-	chkError(err)
+	o.Retries = fs.Int("retries", clientapi.DefaultRetryPolicy().Retries, "Number of times to retry calls to the GoStint API and Vault that fail transiently, e.g. network errors, 503 or a sealed Vault")
+}
+
+// mountFlags adds the flags for where vault's AppRole and transit engines are
+// mounted
+func mountFlags(fs *flag.FlagSet, o *clientapi.ClientOptions) {
+	fs.StringVar(&o.AppRoleMount, "approle-mount", clientapi.DefaultMounts().AppRole, "Path Vault's AppRole auth method, of both the requestor and GoStint, is mounted at")
+	fs.StringVar(&o.TransitMount, "transit-mount", clientapi.DefaultMounts().Transit, "Path Vault's transit secrets engine, with GoStint's key, is mounted at")
+}
+
+// jobFlags adds the flags describing a job to submit
+func jobFlags(fs *flag.FlagSet, c *clientapi.APIRequest) {
+	c.GoStintRole = fs.String("gostint-approle", "gostint-role", "Vault App Role Name of GoStint to run job on (can read file e.g. '@gostint_role.txt')")
+
+	c.JobJSON = fs.String("job-json", "", "JSON or YAML Job request, with ${VAR} and ${VAR:-default} references expanded from -set and the environment (can read file e.g. '@job.yaml')")
+
+	c.QName = fs.String("qname", "", "Job Queue to submit to, overrides value in job-json")
+	c.ContainerImage = fs.String("image", "", "Docker image to run job within, overrides value in job-json")
+	c.ImagePullPolicy = fs.String("image-pull-policy", "IfNotPresent", "Docker image pull policy: IfNotPresent or Always")
+	c.Content = fs.String("content", "", "Folder or targz to inject into the container relative to root '/' folder, overrides value in job-json")
+	c.EntryPoint = fs.String("entrypoint", "", "JSON array of string parts defining the container's entrypoint, e.g.: '[\"ansible\"]', overrides value in job-json")
+	c.Run = fs.String("run", "", "JSON array of string parts defining the command to run in the container - aka the job, e.g.: '[\"-m\", \"ping\", \"127.0.0.1\"]', overrides value in job-json")
+	c.WorkingDir = fs.String("run-dir", "", "Working directory within the container to run the job")
+	c.EnvVars = fs.String("env-vars", "", "JSON array of strings providing envronment variables to be passed to the job container, e.g.: '[\"MYVAR=value\"]'")
+	c.SecretRefs = fs.String("secret-refs", "", "JSON array of strings providing paths to secrets in the Vault to be injected into the job's container, e.g.: '[\"mysecret@secret/data/my-secret.my-value\", ...]', overrides value in job-json")
+	c.SecretFileType = fs.String("secret-filetype", "yaml", "Injected secret file type, can be either 'yaml' (default) or 'json', overrides value in job-json")
+	c.ContOnWarnings = fs.Bool("cont-on-warnings", false, "Continue to run job even if vault reported warnings when looking up secret refs, overrides value in job-json")
+	c.Strict = fs.Bool("strict", false, "Reject fields in job-json unknown to this client, instead of warning and passing them to gostint unchanged")
+	c.IdempotencyKey = fs.String("idempotency-key", "", "Key identifying this submission, re-running with the same key attaches to the job already submitted with it instead of submitting it again - defaults to a new random key")
+}
+
+// secondsOrDuration is a flag value given as whole seconds, e.g. 2, or as a
+// duration, e.g. 500ms
+type secondsOrDuration time.Duration
+
+func (d *secondsOrDuration) String() string {
+	return time.Duration(*d).String()
+}
 
-	res, err := clientapi.RunJob(&c, *deb, *pollIntervalSecs, *waitFor)
+func (d *secondsOrDuration) Set(s string) error {
+	if secs, err := strconv.Atoi(s); err == nil {
+		*d = secondsOrDuration(time.Duration(secs) * time.Second)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("must be whole seconds or a duration, e.g. 500ms")
+	}
+	*d = secondsOrDuration(v)
+	return nil
+}
+
+// pollFlags adds the flags controlling how jobs are polled while waiting,
+// returning a func giving the policy once parsed
+func pollFlags(fs *flag.FlagSet) func() clientapi.PollPolicy {
+	def := clientapi.DefaultPollPolicy()
+	interval := secondsOrDuration(def.Interval)
+	maxInterval := secondsOrDuration(def.MaxInterval)
+	fs.Var(&interval, "poll-interval", "Initial poll interval for results, in seconds or as a duration e.g. 500ms")
+	fs.Var(&maxInterval, "poll-max-interval", "Maximum poll interval the backoff grows to, in seconds or as a duration")
+	multiplier := fs.Float64("poll-backoff", def.Multiplier, "Multiplier applied to the poll interval after each poll, 1 for a fixed interval")
+	jitter := fs.Float64("poll-jitter", def.Jitter, "Randomise each poll interval by up to this fraction, e.g. 0.2 for +/-20%")
+	deadline := fs.Duration("wait-deadline", 0, "Give up waiting, and kill the job, if it has not completed within this duration (default none)")
+	queued := fs.Duration("queued-deadline", 0, "Give up waiting, and kill the job, if it is still queued after this duration (default none)")
+	running := fs.Duration("running-deadline", 0, "Give up waiting, and kill the job, if it is still running after this duration (default none)")
+
+	return func() clientapi.PollPolicy {
+		return clientapi.PollPolicy{
+			Interval:        time.Duration(interval),
+			Multiplier:      *multiplier,
+			MaxInterval:     time.Duration(maxInterval),
+			Jitter:          *jitter,
+			Deadline:        *deadline,
+			QueuedDeadline:  *queued,
+			RunningDeadline: *running,
+		}
+	}
+}
+
+// resolveConn reads any @file connection arguments
+func resolveConn(c *clientapi.APIRequest) error {
+	for _, p := range []*string{c.AppRoleID, c.AppSecretID, c.Token} {
+		if err := tryResolveFile(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newClient returns a client for the validated and resolved connection
+// arguments
+func newClient(c *clientapi.APIRequest, o clientapi.ClientOptions, deb bool, opts ...clientapi.Option) *clientapi.Client {
+	if o.Insecure {
+		warn("Warning: -insecure set, the GoStint API's TLS certificate will NOT be verified")
+	}
+
+	cl, err := clientapi.NewClient(append([]clientapi.Option{
+		clientapi.WithRequest(c),
+		clientapi.WithClientOptions(o),
+		clientapi.WithDebug(deb),
+	}, opts...)...)
 	chkError(err)
+	return cl
+}
 
-	debug("Final job state: %v", res)
-	if res.Status == "success" {
-		fmt.Printf(res.Output)
-	} else {
-		color.HiRed(fmt.Sprintf("[%s] %s", res.Status, res.Output))
-		if res.ReturnCode == 0 {
-			// force non-zero rc - this can happen if executable not found in the container
-			os.Exit(1)
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if args[0] == "help" {
+			usage()
+			os.Exit(0)
 		}
+		for _, cmd := range commands {
+			if cmd.name == args[0] {
+				cmd.run(args[1:])
+				return
+			}
+		}
+		usage()
+		chkError(fmt.Errorf("unknown command '%s'", args[0]))
 	}
-	os.Exit(res.ReturnCode)
+
+	// flags only, run a job as before subcommands were introduced
+	cmdRun(args)
 }
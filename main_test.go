@@ -0,0 +1,110 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/goethite/gostint-client/clientapi"
+)
+
+func TestExitCodes(t *testing.T) {
+	defer func() { exitCodes = exitCodesJob }()
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	for _, tc := range []struct {
+		err         error
+		job, client int
+	}{
+		{&clientapi.APIError{StatusCode: 403}, exitNoPerm, exitNoPerm},
+		{fmt.Errorf("job 1: %w", &clientapi.APIError{StatusCode: 404}), exitNotFound, exitNotFound},
+		{&clientapi.APIError{StatusCode: 503}, exitTempFail, exitTempFail},
+		{dialErr, exitTempFail, exitTempFail},
+		{&clientapi.APIError{StatusCode: 500}, exitUnavailable, exitUnavailable},
+		{errors.New("bad flag"), exitError, exitClientError},
+		{fmt.Errorf("2 of 3 jobs %w", errJobsFailed), exitError, exitError},
+	} {
+		exitCodes = exitCodesJob
+		if got := errorExitCode(tc.err); got != tc.job {
+			t.Errorf("%v: got %d, want %d", tc.err, got, tc.job)
+		}
+		exitCodes = exitCodesClient
+		if got := errorExitCode(tc.err); got != tc.client {
+			t.Errorf("%v with -exit-code=client: got %d, want %d", tc.err, got, tc.client)
+		}
+	}
+
+	for _, tc := range []struct {
+		status      string
+		rc          int
+		job, client int
+	}{
+		{"success", 0, 0, 0},
+		{"failed", 75, 75, exitError},
+		{"failed", 0, 1, exitError}, // e.g. the executable wasn't found
+		{"stopping", 0, 1, exitError},
+	} {
+		res := &clientapi.GetResponse{Status: tc.status, ReturnCode: tc.rc}
+		exitCodes = exitCodesJob
+		if got := exitCode(res); got != tc.job {
+			t.Errorf("%s %d: got %d, want %d", tc.status, tc.rc, got, tc.job)
+		}
+		exitCodes = exitCodesClient
+		if got := exitCode(res); got != tc.client {
+			t.Errorf("%s %d with -exit-code=client: got %d, want %d", tc.status, tc.rc, got, tc.client)
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	deb := fs.Bool("debug", false, "")
+	qname := fs.String("qname", "", "")
+	pos := parseArgs(fs, []string{"-debug", "job-1", "-qname", "play", "job-2"})
+	if !reflect.DeepEqual(pos, []string{"job-1", "job-2"}) || !*deb || *qname != "play" {
+		t.Errorf("got %q, debug %v, qname %q", pos, *deb, *qname)
+	}
+}
+
+func TestCommandNames(t *testing.T) {
+	seen := map[string]bool{}
+	for _, cmd := range commands {
+		if seen[cmd.name] {
+			t.Errorf("command %s is listed twice", cmd.name)
+		}
+		seen[cmd.name] = true
+	}
+	for _, name := range []string{"run", "status", "wait", "output", "kill", "list"} {
+		if !seen[name] {
+			t.Errorf("no %s command", name)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/goethite/gostint-client/clientapi"
+)
+
+// Output formats for results
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+	formatJUnit = "junit"
+	formatTAP   = "tap"
+)
+
+// Job results in reports
+const (
+	resultPass  = "pass"
+	resultFail  = "fail"
+	resultError = "error"
+	resultSkip  = "skip"
+	resultQueue = "pending" // not waited for, still queued or running
+)
+
+func outputFormatFlag(fs *flag.FlagSet) *string {
+	return fs.String("output-format", formatText, "Result output format: text, json, yaml, junit or tap")
+}
+
+func validateOutputFormat(format string) error {
+	switch format {
+	case formatText, formatJSON, formatYAML, formatJUnit, formatTAP:
+		return nil
+	}
+	return fmt.Errorf("invalid output-format '%s', must be text, json, yaml, junit or tap", format)
+}
+
+// timings are measured by the client
+type timings struct {
+	Started      time.Time `json:"started"`
+	Ended        time.Time `json:"ended"`
+	DurationSecs float64   `json:"duration_secs"`
+}
+
+func newTimings(started time.Time, d time.Duration) timings {
+	return timings{
+		Started:      started,
+		Ended:        started.Add(d),
+		DurationSecs: d.Seconds(),
+	}
+}
+
+// jobReport is the result of one job
+type jobReport struct {
+	Name    string                 `json:"name,omitempty"`
+	Result  string                 `json:"result"`
+	Reason  string                 `json:"reason,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+	Job     *clientapi.GetResponse `json:"job,omitempty"`
+	Timings timings                `json:"timings"`
+}
+
+// report combines the results of a batch, matrix or pipeline run
+type report struct {
+	Name    string      `json:"name"`
+	Total   int         `json:"total"`
+	Passed  int         `json:"passed"`
+	Failed  int         `json:"failed"`
+	Errors  int         `json:"errors"`
+	Skipped int         `json:"skipped"`
+	Timings timings     `json:"timings"`
+	Jobs    []jobReport `json:"jobs"`
+}
+
+func newJobReport(name string, res *clientapi.GetResponse, err error, started time.Time, d time.Duration) jobReport {
+	jr := jobReport{
+		Name:    name,
+		Job:     res,
+		Timings: newTimings(started, d),
+	}
+	switch {
+	case err != nil:
+		jr.Result = resultError
+		jr.Error = err.Error()
+	case res.Status == "success":
+		jr.Result = resultPass
+	case res.Status == "queued" || res.Status == "running":
+		jr.Result = resultQueue
+	default:
+		jr.Result = resultFail
+	}
+	return jr
+}
+
+func batchJobReport(r clientapi.BatchResult) jobReport {
+	return newJobReport(r.Name, r.Response, r.Err, r.Started, r.Duration)
+}
+
+func batchJobReports(results []clientapi.BatchResult) []jobReport {
+	reports := []jobReport{}
+	for _, r := range results {
+		reports = append(reports, batchJobReport(r))
+	}
+	return reports
+}
+
+func stepJobReport(r clientapi.StepResult) jobReport {
+	if r.Skipped {
+		return jobReport{
+			Name:    r.Name,
+			Result:  resultSkip,
+			Reason:  r.Reason,
+			Timings: newTimings(r.Started, r.Duration),
+		}
+	}
+	return batchJobReport(r.BatchResult)
+}
+
+func newReport(name string, started time.Time, jobs []jobReport) report {
+	rep := report{
+		Name:    name,
+		Total:   len(jobs),
+		Timings: newTimings(started, time.Since(started)),
+		Jobs:    jobs,
+	}
+	for _, j := range jobs {
+		switch j.Result {
+		case resultPass:
+			rep.Passed++
+		case resultFail:
+			rep.Failed++
+		case resultError:
+			rep.Errors++
+		case resultSkip, resultQueue:
+			rep.Skipped++
+		}
+	}
+	return rep
+}
+
+// writeJobReport writes a single job's result in the format
+func writeJobReport(w io.Writer, format string, jr jobReport) error {
+	switch format {
+	case formatJSON, formatYAML:
+		return writeStructured(w, format, jr)
+	}
+	return writeReport(w, format, newReport(jr.Name, jr.Timings.Started, []jobReport{jr}))
+}
+
+// writeReport writes the combined results in the format
+func writeReport(w io.Writer, format string, rep report) error {
+	switch format {
+	case formatJSON, formatYAML:
+		return writeStructured(w, format, rep)
+	case formatJUnit:
+		return writeJUnit(w, rep)
+	case formatTAP:
+		return writeTAP(w, rep)
+	}
+	return fmt.Errorf("output-format %s is not a report format", format)
+}
+
+func writeStructured(w io.Writer, format string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if format == formatYAML {
+		if b, err = yaml.JSONToYAML(b); err != nil {
+			return err
+		}
+	} else {
+		b = append(b, '\n')
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func junitTime(t timings) string {
+	return fmt.Sprintf("%.3f", t.DurationSecs)
+}
+
+func writeJUnit(w io.Writer, rep report) error {
+	suite := junitTestSuite{
+		Name:      rep.Name,
+		Tests:     rep.Total,
+		Failures:  rep.Failed,
+		Errors:    rep.Errors,
+		Skipped:   rep.Skipped,
+		Time:      junitTime(rep.Timings),
+		Timestamp: rep.Timings.Started.Format(time.RFC3339),
+	}
+	for i, j := range rep.Jobs {
+		name := j.Name
+		if name == "" {
+			name = fmt.Sprintf("job-%d", i+1)
+		}
+		tc := junitTestCase{
+			Name:      name,
+			ClassName: "gostint",
+			Time:      junitTime(j.Timings),
+		}
+		if j.Job != nil {
+			if j.Job.QName != "" {
+				tc.ClassName = "gostint." + j.Job.QName
+			}
+			tc.SystemOut = j.Job.Output
+		}
+		switch j.Result {
+		case resultFail:
+			tc.Failure = &junitMessage{
+				Message: fmt.Sprintf("job %s %s with return code %d", j.Job.ID, j.Job.Status, j.Job.ReturnCode),
+				Text:    j.Job.Output,
+			}
+		case resultError:
+			tc.Error = &junitMessage{Message: j.Error}
+		case resultSkip:
+			tc.Skipped = &junitMessage{Message: j.Reason}
+		case resultQueue:
+			tc.Skipped = &junitMessage{Message: fmt.Sprintf("job %s is %s", j.Job.ID, j.Job.Status)}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suites := junitTestSuites{
+		Name:     rep.Name,
+		Tests:    rep.Total,
+		Failures: rep.Failed,
+		Errors:   rep.Errors,
+		Skipped:  rep.Skipped,
+		Time:     junitTime(rep.Timings),
+		Suites:   []junitTestSuite{suite},
+	}
+	b, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, b)
+	return err
+}
+
+func writeTAP(w io.Writer, rep report) error {
+	fmt.Fprintf(w, "TAP version 13\n1..%d\n", len(rep.Jobs))
+	for i, j := range rep.Jobs {
+		name := strings.Replace(j.Name, "#", "\\#", -1)
+		switch j.Result {
+		case resultPass:
+			fmt.Fprintf(w, "ok %d - %s\n", i+1, name)
+		case resultSkip:
+			fmt.Fprintf(w, "ok %d - %s # SKIP %s\n", i+1, name, j.Reason)
+			continue
+		case resultQueue:
+			fmt.Fprintf(w, "ok %d - %s # SKIP job %s is %s\n", i+1, name, j.Job.ID, j.Job.Status)
+			continue
+		default:
+			fmt.Fprintf(w, "not ok %d - %s\n", i+1, name)
+		}
+
+		// YAML diagnostics block
+		diag := map[string]interface{}{
+			"duration_secs": j.Timings.DurationSecs,
+		}
+		if j.Error != "" {
+			diag["error"] = j.Error
+		}
+		if j.Job != nil {
+			diag["id"] = j.Job.ID
+			diag["status"] = j.Job.Status
+			diag["return_code"] = j.Job.ReturnCode
+			if j.Result != resultPass {
+				diag["output"] = j.Job.Output
+			}
+		}
+		b, err := yaml.Marshal(diag)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "  ---")
+		for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+		fmt.Fprintln(w, "  ...")
+	}
+	return nil
+}
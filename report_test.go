@@ -0,0 +1,132 @@
+/*
+This file is part of gostint.
+
+MIT License
+
+Copyright (c) 2018 Graham Lee Bevan <graham.bevan@ntlworld.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goethite/gostint-client/clientapi"
+)
+
+func testReport() report {
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return newReport("nightly", started, []jobReport{
+		newJobReport("build", &clientapi.GetResponse{ID: "1", Status: "success", QName: "ci"}, nil, started, time.Second),
+		newJobReport("test #2", &clientapi.GetResponse{ID: "2", Status: "failed", ReturnCode: 3, Output: "boom"}, nil, started, 2*time.Second),
+		newJobReport("deploy", nil, errors.New("vault sealed"), started, 0),
+		stepJobReport(clientapi.StepResult{BatchResult: clientapi.BatchResult{Name: "notify"}, Skipped: true, Reason: "needs deploy"}),
+		newJobReport("later", &clientapi.GetResponse{ID: "5", Status: "queued"}, nil, started, 0),
+	})
+}
+
+func TestNewReport(t *testing.T) {
+	rep := testReport()
+	if rep.Total != 5 || rep.Passed != 1 || rep.Failed != 1 || rep.Errors != 1 || rep.Skipped != 2 {
+		t.Errorf("got %d total, %d passed, %d failed, %d errors, %d skipped", rep.Total, rep.Passed, rep.Failed, rep.Errors, rep.Skipped)
+	}
+	results := []string{}
+	for _, j := range rep.Jobs {
+		results = append(results, j.Result)
+	}
+	want := []string{resultPass, resultFail, resultError, resultSkip, resultQueue}
+	if strings.Join(results, ",") != strings.Join(want, ",") {
+		t.Errorf("got results %v, want %v", results, want)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	rep := testReport()
+
+	var b bytes.Buffer
+	if err := writeReport(&b, formatJSON, rep); err != nil {
+		t.Fatal(err)
+	}
+	var decoded report
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Total != rep.Total || decoded.Jobs[1].Job.ReturnCode != 3 {
+		t.Errorf("json report did not round trip: %s", b.String())
+	}
+
+	b.Reset()
+	if err := writeReport(&b, formatJUnit, rep); err != nil {
+		t.Fatal(err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(b.Bytes(), &suites); err != nil {
+		t.Fatal(err)
+	}
+	cases := suites.Suites[0].Cases
+	if suites.Tests != 5 || suites.Failures != 1 || suites.Errors != 1 || suites.Skipped != 2 || len(cases) != 5 {
+		t.Fatalf("got junit %s", b.String())
+	}
+	if cases[0].ClassName != "gostint.ci" || cases[0].Time != "1.000" {
+		t.Errorf("got passing case %+v", cases[0])
+	}
+	if cases[1].Failure == nil || cases[1].Failure.Message != "job 2 failed with return code 3" || cases[1].Failure.Text != "boom" {
+		t.Errorf("got failing case %+v", cases[1])
+	}
+	if cases[2].Error == nil || cases[2].Error.Message != "vault sealed" {
+		t.Errorf("got erroring case %+v", cases[2])
+	}
+	if cases[3].Skipped == nil || cases[3].Skipped.Message != "needs deploy" {
+		t.Errorf("got skipped case %+v", cases[3])
+	}
+	if cases[4].Skipped == nil || cases[4].Skipped.Message != "job 5 is queued" {
+		t.Errorf("got queued case %+v", cases[4])
+	}
+
+	b.Reset()
+	if err := writeReport(&b, formatTAP, rep); err != nil {
+		t.Fatal(err)
+	}
+	tap := b.String()
+	for _, want := range []string{
+		"TAP version 13\n1..5\nok 1 - build\n",
+		"not ok 2 - test \\#2\n  ---\n",
+		"  output: boom\n",
+		"  return_code: 3\n",
+		"not ok 3 - deploy\n  ---\n  duration_secs: 0\n  error: vault sealed\n  ...\n",
+		"ok 4 - notify # SKIP needs deploy\n",
+		"ok 5 - later # SKIP job 5 is queued\n",
+	} {
+		if !strings.Contains(tap, want) {
+			t.Errorf("tap output missing %q:\n%s", want, tap)
+		}
+	}
+
+	if err := writeReport(&b, formatText, rep); err == nil {
+		t.Error("text is not a report format")
+	}
+}